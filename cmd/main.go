@@ -1,18 +1,20 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/anotherhadi/search-nixos-api/indexer"
-	"github.com/anotherhadi/search-nixos-api/indexer/darwin"
-	"github.com/anotherhadi/search-nixos-api/indexer/homemanager"
-	"github.com/anotherhadi/search-nixos-api/indexer/nixos"
-	"github.com/anotherhadi/search-nixos-api/indexer/nixpkgs"
-	"github.com/anotherhadi/search-nixos-api/indexer/nur"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -47,14 +49,104 @@ func main() {
 		panic(err)
 	}
 
-	index := indexer.GetIndex(indexPath)
+	// Local sources are registered first, so that they can be weighted
+	optionSources, err := indexer.ParseLocalOptionsSources(os.Getenv("OPTION_SOURCES"))
+	if err != nil {
+		panic(err)
+	}
+	for _, source := range optionSources {
+		indexer.RegisterSource(source)
+	}
+
+	sourceWeights, err := indexer.ParseSourceWeights(os.Getenv("SOURCE_WEIGHTS"))
+	if err != nil {
+		panic(err)
+	}
+	sourceWeights = indexer.DefaultSourceWeights.Merge(sourceWeights)
+
+	// Location and tag of the releases of the default channel, used without
+	// CHANNELS, which sets them for each channel instead
+	for _, name := range []string{"RELEASE_URL", "RELEASE_MIRRORS", "RELEASE_TAG"} {
+		if os.Getenv(name) != "" && os.Getenv("CHANNELS") != "" {
+			panic(name + " can't be used with CHANNELS, which sets the location of each channel, pinned with /admin/pin")
+		}
+	}
+	if releaseUrl := os.Getenv("RELEASE_URL"); releaseUrl != "" {
+		indexer.DefaultChannel.URL = releaseUrl
+	}
+	indexer.DefaultChannel.Mirrors = indexer.ParseMirrors(os.Getenv("RELEASE_MIRRORS"))
+	indexer.DefaultChannel.Tag = os.Getenv("RELEASE_TAG")
+
+	channels, err := indexer.ParseChannels(os.Getenv("CHANNELS"))
+	if err != nil {
+		panic(err)
+	}
 
-	// Update the index every {interval} hours
+	// Pins set with /admin/pin take precedence over RELEASE_TAG, an empty one
+	// being the latest release
+	pinsPath := filepath.Join(filepath.Dir(indexPath), indexer.PinsFile)
+	pins, err := indexer.LoadPins(pinsPath)
+	if err != nil {
+		panic(err)
+	}
+	for i, channel := range channels {
+		if tag, ok := pins[channel.Name]; ok {
+			channels[i].Tag = tag
+		}
+		if err := channels[i].CheckPin(); err != nil {
+			panic(err)
+		}
+	}
+
+	// Admin endpoints are disabled without a token
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
+	defaultChannel := channels[0].Name
+	var indexMu sync.RWMutex
+	// downloadMu serializes the downloads of the reloads and of /admin/pin,
+	// which also holds indexMu to move the pin of a channel
+	var downloadMu sync.Mutex
+	indexes := map[string]indexer.Index{}
+	for i, channel := range channels {
+		indexes[channel.Name] = indexer.GetIndex(indexer.ChannelIndexPath(indexPath, channel, i == 0), channel)
+	}
+	// currentIndex returns the index of the channel of a request (the channel
+	// parameter, or the first channel), replying with an error if there is none.
+	// Handlers must call it once per request so that a reload in the middle of
+	// a request doesn't mix two generations.
+	currentIndex := func(c *gin.Context) (indexer.Index, bool) {
+		name := c.Query("channel")
+		if name == "" {
+			name = defaultChannel
+		}
+		indexMu.RLock()
+		defer indexMu.RUnlock()
+		index, ok := indexes[name]
+		if !ok {
+			c.JSON(400, gin.H{"error": "Unknown channel " + strconv.Quote(name)})
+		}
+		return index, ok
+	}
+
+	// Update the indexes every {interval} hours
 	go func() {
 		for {
 			time.Sleep(intervalTime)
-			indexer.DownloadReleases(indexPath)
-			index = indexer.GetIndex(indexPath)
+			for i := range channels {
+				downloadMu.Lock()
+				channel := channels[i]
+				path := indexer.ChannelIndexPath(indexPath, channel, i == 0)
+				if err := indexer.DownloadReleases(path, channel); err != nil {
+					log.Println("Keeping the index of the", channel.Name, "channel:", err)
+					downloadMu.Unlock()
+					continue
+				}
+				newIndex := indexer.GetIndex(path, channel)
+				indexMu.Lock()
+				indexes[channel.Name] = newIndex
+				indexMu.Unlock()
+				downloadMu.Unlock()
+			}
 		}
 	}()
 
@@ -73,6 +165,7 @@ func main() {
 			"Cache-Control",
 			"Expires",
 			"Pragma",
+			"Authorization",
 		},
 	}))
 
@@ -81,14 +174,36 @@ func main() {
 	})
 
 	r.GET("/index.json", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
 		c.JSON(200, index)
 	})
 
+	r.GET("/channels", func(c *gin.Context) {
+		indexMu.RLock()
+		defer indexMu.RUnlock()
+		results := []map[string]string{}
+		for _, channel := range channels {
+			results = append(results, indexes[channel.Name].Info)
+		}
+		c.JSON(200, gin.H{"default": defaultChannel, "channels": results})
+	})
+
 	r.GET("/stats", func(c *gin.Context) {
-		c.JSON(200, index.Info)
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
+		c.JSON(200, index.Stats())
 	})
 
 	r.GET("/search", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
 		query := c.Query("q")
 		if query == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Le paramètre de requête 'q' est requis"})
@@ -119,94 +234,366 @@ func main() {
 		} else if perPageInt < 1 {
 			c.JSON(400, gin.H{"error": "per_page number must be greater than 0"})
 			return
+		} else if perPageInt > indexer.MaxPerPage {
+			c.JSON(400, gin.H{"error": "per_page number must be at most " + strconv.Itoa(indexer.MaxPerPage)})
+			return
+		}
+
+		order, err := indexer.ParseSortOrder(c.Query("sort"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		weights, err := indexer.ParseSourceWeights(c.Query("weights"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
+		weights = sourceWeights.Merge(weights)
 
-		results := index.Search(query)
+		highlight := c.Query("highlight") == "true"
+
+		fields, err := indexer.ParseFields(c.Query("fields"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		filters := map[string][]string{}
+		for _, facet := range indexer.FacetNames {
+			if values := c.QueryArray(facet); len(values) > 0 {
+				filters[facet] = values
+			}
+		}
+
+		// Cursors are only valid for the same request on the same generation of the index.
+		fingerprint := []string{c.Query("channel"), query, c.Query("mode"), c.Query("sort"), c.Query("weights"), c.Query("group")}
+		for _, facet := range indexer.FacetNames {
+			fingerprint = append(fingerprint, facet+"="+strings.Join(filters[facet], ","))
+		}
+		cursor := indexer.Cursor{
+			Generation: index.Generation(),
+			Query:      indexer.Fingerprint(fingerprint...),
+			Offset:     (pageInt - 1) * perPageInt,
+		}
+		if c.Query("cursor") != "" {
+			if c.Query("page") != "" {
+				c.JSON(400, gin.H{"error": "The page and cursor parameters are mutually exclusive"})
+				return
+			}
+			cursor, err = indexer.DecodeCursor(c.Query("cursor"), cursor.Generation, cursor.Query)
+			if errors.Is(err, indexer.ErrStaleCursor) {
+				c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+				return
+			} else if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			pageInt = cursor.Offset/perPageInt + 1
+		}
+
+		// Only the requested page has to be in order, unless the results are grouped
+		sortLimit := cursor.Offset + perPageInt
+		if c.Query("group") == "true" {
+			sortLimit = math.MaxInt
+		}
+
+		var results []indexer.PackageOrOption
+		var highlightPage func(page []indexer.PackageOrOption)
+		suggestions := []string{}
+		expansions := []indexer.Expansion{}
+		switch c.Query("mode") {
+		case "", "query":
+			q, err := indexer.ParseQuery(query)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid query: " + err.Error()})
+				return
+			}
+			expanded, applied := index.ExpandSynonyms(q)
+			expansions = applied
+			results = index.SearchQuery(expanded, indexer.SearchOptions{SourceWeights: weights, Unsorted: true})
+			if len(results) == 0 {
+				suggestions = index.Suggest(q)
+			}
+			highlightPage = func(page []indexer.PackageOrOption) { index.Highlight(expanded, page) }
+		case "regex":
+			results, err = index.SearchRegex(query)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid regular expression: " + err.Error()})
+				return
+			}
+			re, _ := indexer.CompileSearchRegex(query)
+			highlightPage = func(page []indexer.PackageOrOption) { indexer.HighlightRegex(re, page) }
+		default:
+			c.JSON(400, gin.H{"error": "Invalid mode, expected query or regex"})
+			return
+		}
+
+		if len(filters) > 0 {
+			results, err = index.FilterFacets(results, filters)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		// Regex results are ordered by key length unless sorted explicitly
+		if c.Query("mode") != "regex" || c.Query("sort") != "" {
+			index.SortTop(results, order, sortLimit)
+		}
+
+		var facets indexer.Facets
+		if c.Query("facets") == "true" {
+			facets = index.Facets(results)
+		}
+
+		if c.Query("group") == "true" {
+			results = indexer.GroupAlternates(results)
+		}
 
 		if len(results) == 0 {
-			c.JSON(
-				200,
-				gin.H{
-					"results":    []indexer.PackageOrOption{},
-					"total":      0,
-					"page":       pageInt,
-					"per_page":   perPageInt,
-					"totalPages": 1,
-				},
-			)
+			response := gin.H{
+				"results":     []indexer.PackageOrOption{},
+				"total":       0,
+				"page":        pageInt,
+				"per_page":    perPageInt,
+				"totalPages":  1,
+				"suggestions": suggestions,
+			}
+			if facets != nil {
+				response["facets"] = facets
+			}
+			if len(expansions) > 0 {
+				response["expansions"] = expansions
+			}
+			c.JSON(200, response)
 			return
 		}
 
 		total := len(results)
 		totalPages := (total + perPageInt - 1) / perPageInt
-		if pageInt > totalPages {
+		if cursor.Offset >= total {
+			if c.Query("cursor") != "" {
+				c.JSON(400, gin.H{"error": indexer.ErrInvalidCursor.Error()})
+				return
+			}
 			c.JSON(400, gin.H{"error": "Page number exceeds total pages"})
 			return
 		}
 
-		if total > perPageInt {
-			start := (pageInt - 1) * perPageInt
-			end := start + perPageInt
-			end = min(end, total)
+		start := cursor.Offset
+		end := min(start+perPageInt, total)
+		results = results[start:end]
 
-			results = results[start:end]
+		if highlight {
+			highlightPage(results)
 		}
+		index.SelectFields(results, fields)
 
-		c.JSON(
-			200,
-			gin.H{
-				"results":    results,
-				"total":      total,
-				"totalPages": totalPages,
-				"page":       pageInt,
-				"per_page":   perPageInt,
-			},
-		)
+		response := gin.H{
+			"results":    results,
+			"total":      total,
+			"totalPages": totalPages,
+			"page":       pageInt,
+			"per_page":   perPageInt,
+		}
+		if end < total {
+			cursor.Offset = end
+			response["nextCursor"] = cursor.Encode()
+		}
+		if facets != nil {
+			response["facets"] = facets
+		}
+		if len(expansions) > 0 {
+			response["expansions"] = expansions
+		}
+		c.JSON(200, response)
 	})
 
-	r.GET(nixpkgs.Prefix+":q", func(c *gin.Context) {
-		query := c.Param("q")
-		if result, found := index.Nixpkgs[query]; found {
-			c.JSON(200, result)
-		} else {
-			c.JSON(404, gin.H{"error": "Not found"})
+	for _, source := range indexer.Sources() {
+		r.GET(source.Prefix()+":q", func(c *gin.Context) {
+			index, ok := currentIndex(c)
+			if !ok {
+				return
+			}
+			query := c.Param("q")
+			var result any
+			var found bool
+			switch source.Kind() {
+			case indexer.KindPackage:
+				result, found = index.PackageDetail(source.Name(), query)
+			case indexer.KindOption:
+				result, found = index.OptionDetail(source.Name(), query)
+			}
+			if found {
+				c.JSON(200, result)
+			} else {
+				c.JSON(404, gin.H{"error": "Not found"})
+			}
+		})
+
+		if source.Kind() != indexer.KindPackage {
+			continue
 		}
+		r.GET(source.Prefix()+":q/similar", func(c *gin.Context) {
+			index, ok := currentIndex(c)
+			if !ok {
+				return
+			}
+			limit := c.Query("limit")
+			if limit == "" {
+				limit = "10"
+			}
+			limitInt, err := strconv.Atoi(limit)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid limit number"})
+				return
+			} else if limitInt < 1 || limitInt > indexer.MaxSimilar {
+				c.JSON(400, gin.H{"error": "limit number must be between 1 and " + strconv.Itoa(indexer.MaxSimilar)})
+				return
+			}
+			results, err := index.Similar(source.Name(), c.Param("q"), limitInt)
+			if err != nil {
+				c.JSON(404, gin.H{"error": "Not found"})
+				return
+			}
+			c.JSON(200, gin.H{"key": c.Param("q"), "results": results})
+		})
+	}
+
+	r.GET("/complete", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
+		limit := c.Query("limit")
+		if limit == "" {
+			limit = "10"
+		}
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid limit number"})
+			return
+		} else if limitInt < 1 || limitInt > 100 {
+			c.JSON(400, gin.H{"error": "limit number must be between 1 and 100"})
+			return
+		}
+		completion, err := index.Complete(c.Query("prefix"), limitInt)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, completion)
 	})
 
-	r.GET(nixos.Prefix+":q", func(c *gin.Context) {
-		query := c.Param("q")
-		if result, found := index.Nixos[query]; found {
-			c.JSON(200, result)
-		} else {
-			c.JSON(404, gin.H{"error": "Not found"})
+	r.GET("/options/tree/:source/*path", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
+		path := strings.Trim(strings.ReplaceAll(c.Param("path"), "/", "."), ".")
+		node, err := index.OptionTree(c.Param("source"), path)
+		if err != nil {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
 		}
+		c.JSON(200, node)
 	})
 
-	r.GET(homemanager.Prefix+":q", func(c *gin.Context) {
-		query := c.Param("q")
-		if result, found := index.Homemanager[query]; found {
-			c.JSON(200, result)
+	r.GET("/program/:name", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
+		name := c.Param("name")
+		if results := index.Providers(name); len(results) > 0 {
+			c.JSON(200, gin.H{"program": name, "results": results})
 		} else {
 			c.JSON(404, gin.H{"error": "Not found"})
 		}
 	})
 
-	r.GET(darwin.Prefix+":q", func(c *gin.Context) {
-		query := c.Param("q")
-		if result, found := index.Darwin[query]; found {
-			c.JSON(200, result)
-		} else {
-			c.JSON(404, gin.H{"error": "Not found"})
+	r.POST("/lookup", func(c *gin.Context) {
+		index, ok := currentIndex(c)
+		if !ok {
+			return
+		}
+		var keys []indexer.LookupKey
+		if err := c.ShouldBindJSON(&keys); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid body, expected a list of keys or {source, key} objects: " + err.Error()})
+			return
 		}
+		result, err := index.Lookup(keys)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
 	})
 
-	r.GET(nur.Prefix+":q", func(c *gin.Context) {
-		query := c.Param("q")
-		if result, found := index.Nur[query]; found {
-			c.JSON(200, result)
-		} else {
+	// Pin a channel to a release tag, or back to the latest release with an
+	// empty tag or "latest". The release is downloaded before the pin moves.
+	r.POST("/admin/pin", func(c *gin.Context) {
+		if adminToken == "" {
 			c.JSON(404, gin.H{"error": "Not found"})
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.JSON(401, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		var body struct {
+			Channel string `json:"channel"`
+			Tag     string `json:"tag"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid body, expected {channel, tag}: " + err.Error()})
+			return
+		}
+		if body.Tag == "latest" {
+			body.Tag = ""
+		}
+		if body.Tag != "" && !indexer.ValidTag(body.Tag) {
+			c.JSON(400, gin.H{"error": "Invalid tag " + strconv.Quote(body.Tag)})
+			return
+		}
+		if body.Channel == "" {
+			body.Channel = defaultChannel
+		}
+		i := slices.IndexFunc(channels, func(ch indexer.Channel) bool { return ch.Name == body.Channel })
+		if i < 0 {
+			c.JSON(400, gin.H{"error": "Unknown channel " + strconv.Quote(body.Channel)})
+			return
+		}
+
+		downloadMu.Lock()
+		defer downloadMu.Unlock()
+		channel := channels[i]
+		channel.Tag = body.Tag
+		if err := channel.CheckPin(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		path := indexer.ChannelIndexPath(indexPath, channel, i == 0)
+		// Every source must be of the new release, or the pin doesn't move
+		if err := indexer.DownloadReleasesStrict(path, channel); err != nil {
+			c.JSON(502, gin.H{"error": "Could not download the release: " + err.Error()})
+			return
+		}
+		newIndex := indexer.GetIndex(path, channel)
+
+		indexMu.Lock()
+		channels[i] = channel
+		indexes[channel.Name] = newIndex
+		indexMu.Unlock()
+		// An empty tag is kept, so that unpinning overrides RELEASE_TAG too
+		pins[channel.Name] = channel.Tag
+		if err := indexer.SavePins(pinsPath, pins); err != nil {
+			log.Println("Could not save the pins:", err)
 		}
+		c.JSON(200, newIndex.Info)
 	})
 
 	err = r.Run(":" + port)
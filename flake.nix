@@ -43,9 +43,68 @@
             default = "12h";
             description = "Interval for the search-nixos-api service";
           };
+          releaseUrl = lib.mkOption {
+            type = lib.types.nullOr lib.types.str;
+            default = null;
+            example = "file:///var/lib/nix-json/";
+            description =
+              "Base URL of the release files: an http(s) URL, a file:// URL or a directory, the latest GitHub release if null. Not used with channels";
+          };
+          releaseTag = lib.mkOption {
+            type = lib.types.str;
+            default = "";
+            example = "2025-06-01";
+            description =
+              "Release tag the index is pinned to, the latest release if empty. Not used with channels";
+          };
+          environmentFile = lib.mkOption {
+            type = lib.types.nullOr lib.types.path;
+            default = null;
+            example = "/run/secrets/search-nixos-api.env";
+            description =
+              "File of environment variables kept out of the Nix store, e.g. ADMIN_TOKEN=... to enable the admin endpoints";
+          };
+          releaseMirrors = lib.mkOption {
+            type = lib.types.listOf lib.types.str;
+            default = [ ];
+            example = [ "https://mirror.example.org/nix-json/latest/" ];
+            description =
+              "Base URLs of the release files tried in order when releaseUrl fails. Not used with channels";
+          };
+          channels = lib.mkOption {
+            type = lib.types.str;
+            default = "";
+            example =
+              "unstable=https://github.com/anotherhadi/nix-json/releases/latest/download/,24.11=https://example.org/nix-json/24.11/@nixos-24.11";
+            description =
+              "Channels to index, as name=url or name=url@commit, the first one being the default";
+          };
+          optionSources = lib.mkOption {
+            type = lib.types.str;
+            default = "";
+            example =
+              "mymodules=/etc/search-nixos-api/options.json@https://git.example.org/modules/blob/main/{file}";
+            description =
+              "Extra sources of options, from local options.json files or directories, as name=path or name=path@template";
+          };
+          sourceWeights = lib.mkOption {
+            type = lib.types.str;
+            default = "";
+            example = "nur=0.2,nixos=1.5";
+            description =
+              "Weights multiplying the relevance of the results of each source";
+          };
         };
 
         config = lib.mkIf config.services.search-nixos-api.enable {
+          assertions = [{
+            assertion = config.services.search-nixos-api.channels == ""
+              || (config.services.search-nixos-api.releaseUrl == null
+                && config.services.search-nixos-api.releaseTag == ""
+                && config.services.search-nixos-api.releaseMirrors == [ ]);
+            message =
+              "services.search-nixos-api: releaseUrl, releaseTag and releaseMirrors can't be used with channels";
+          }];
           systemd.services.search-nixos-api = {
             description = "Search NixOS API";
             after = [ "network.target" ];
@@ -59,12 +118,25 @@
               DynamicUser = true;
               StateDirectory = "search-nixos-api";
               ReadWritePaths = [ "/var/lib/search-nixos-api" ];
+              EnvironmentFile = lib.mkIf
+                (config.services.search-nixos-api.environmentFile != null)
+                config.services.search-nixos-api.environmentFile;
               Environment = [
                 "PRODUCTION=true"
                 "PORT=${toString config.services.search-nixos-api.port}"
                 "INTERVAL=${config.services.search-nixos-api.interval}"
                 "INDEX_PATH=${config.services.search-nixos-api.indexPath}"
-              ];
+                "SOURCE_WEIGHTS=${config.services.search-nixos-api.sourceWeights}"
+                "CHANNELS=${config.services.search-nixos-api.channels}"
+                "OPTION_SOURCES=${config.services.search-nixos-api.optionSources}"
+              ] ++ lib.optional (config.services.search-nixos-api.releaseUrl != null)
+                "RELEASE_URL=${config.services.search-nixos-api.releaseUrl}"
+                ++ lib.optional (config.services.search-nixos-api.releaseTag != "")
+                "RELEASE_TAG=${config.services.search-nixos-api.releaseTag}"
+                ++ lib.optional (config.services.search-nixos-api.releaseMirrors != [ ])
+                "RELEASE_MIRRORS=${
+                  lib.concatStringsSep "," config.services.search-nixos-api.releaseMirrors
+                }";
             };
           };
         };
@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// maxCompletionScan is the maximum number of keys scanned by Complete, so that
+// short prefixes matching much of the index stay cheap.
+const maxCompletionScan = 10000
+
+var ErrEmptyPrefix = errors.New("missing prefix to complete")
+
+// Completion holds the completions of a key prefix.
+type Completion struct {
+	Prefix   string           `json:"prefix"`
+	Segments []SegmentCount   `json:"segments"` // possible next path segments
+	Keys     []CompletionItem `json:"keys"`     // top matching keys
+	// Truncated tells that the prefix matches too many keys to scan them all:
+	// counts and keys only cover the first ones, in alphabetical order.
+	Truncated bool `json:"truncated"`
+}
+
+// SegmentCount is a path completed up to the end of its segment,
+// with the number of keys under it.
+type SegmentCount struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// CompletionItem is a key completing a prefix.
+type CompletionItem struct {
+	Key     string `json:"key"`
+	Type    string `json:"type"`    // "package" or "option"
+	Section string `json:"section"` // source of the index the key comes from, as in filters, e.g. "nixos"
+	Source  string `json:"source"`  // source of the record, e.g. "nixpkgs" for NixOS options
+}
+
+// Complete returns the completions of a key prefix, case insensitively:
+// up to limit next path segments ("services.ngi" gives "services.nginx"), most
+// common first, and up to limit keys starting with the prefix, shortest first.
+// Paths of the segments are lowercased. At most maxCompletionScan keys are scanned.
+func (index Index) Complete(prefix string, limit int) (Completion, error) {
+	if prefix == "" {
+		return Completion{}, ErrEmptyPrefix
+	}
+	s := index.inverted()
+
+	lower := strings.ToLower(prefix)
+	start := sort.Search(len(s.sorted), func(i int) bool {
+		return s.docs[s.sorted[i]].lower >= lower
+	})
+	end := start
+	for end < len(s.sorted) && end-start < maxCompletionScan &&
+		strings.HasPrefix(s.docs[s.sorted[end]].lower, lower) {
+		end++
+	}
+	matching := s.sorted[start:end]
+
+	res := Completion{Prefix: prefix, Segments: []SegmentCount{}, Keys: []CompletionItem{}}
+	res.Truncated = end < len(s.sorted) && strings.HasPrefix(s.docs[s.sorted[end]].lower, lower)
+
+	counts := map[string]int{}
+	for _, id := range matching {
+		key := s.docs[id].lower
+		path := key
+		if i := strings.IndexByte(key[len(lower):], '.'); i >= 0 {
+			path = key[:len(lower)+i]
+		}
+		counts[path]++
+	}
+	for path, count := range counts {
+		res.Segments = append(res.Segments, SegmentCount{Path: path, Count: count})
+	}
+	sort.Slice(res.Segments, func(i, j int) bool {
+		if res.Segments[i].Count != res.Segments[j].Count {
+			return res.Segments[i].Count > res.Segments[j].Count
+		}
+		return res.Segments[i].Path < res.Segments[j].Path
+	})
+	if len(res.Segments) > limit {
+		res.Segments = res.Segments[:limit]
+	}
+
+	top := make([]int32, len(matching))
+	copy(top, matching)
+	sort.SliceStable(top, func(i, j int) bool {
+		return len(s.docs[top[i]].key) < len(s.docs[top[j]].key)
+	})
+	for i := 0; i < len(top) && i < limit; i++ {
+		d := &s.docs[top[i]]
+		res.Keys = append(res.Keys, CompletionItem{Key: d.key, Type: string(d.kind), Section: d.section, Source: d.source})
+	}
+	return res, nil
+}
@@ -0,0 +1,54 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	index := NewIndex(nil, map[string]Packages{
+		"nixpkgs": {"nginx": {Source: "nixpkgs"}, "nginxMainline": {Source: "nixpkgs"}},
+	}, map[string]Options{
+		"nixos": {
+			"services.nginx.enable":     {Source: "nixpkgs"},
+			"services.nginx.package":    {Source: "nixpkgs"},
+			"services.nix-serve.enable": {Source: "nixpkgs"},
+		},
+		"home-manager": {"services.nextcloud-client.enable": {Source: "home-manager"}},
+	})
+
+	got, err := index.Complete("Services.N", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Completion{
+		Prefix: "Services.N",
+		Segments: []SegmentCount{
+			{Path: "services.nginx", Count: 2},
+			{Path: "services.nextcloud-client", Count: 1},
+		},
+		Keys: []CompletionItem{
+			{Key: "services.nginx.enable", Type: "option", Section: "nixos", Source: "nixpkgs"},
+			{Key: "services.nginx.package", Type: "option", Section: "nixos", Source: "nixpkgs"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Complete() = %+v, want %+v", got, want)
+	}
+
+	got, err = index.Complete("nginx", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKeys := []CompletionItem{
+		{Key: "nginx", Type: "package", Section: "nixpkgs", Source: "nixpkgs"},
+		{Key: "nginxMainline", Type: "package", Section: "nixpkgs", Source: "nixpkgs"},
+	}
+	if !reflect.DeepEqual(got.Keys, wantKeys) {
+		t.Errorf("Complete() keys = %+v, want %+v", got.Keys, wantKeys)
+	}
+
+	if _, err := index.Complete("", 10); err != ErrEmptyPrefix {
+		t.Errorf("Complete(\"\") error = %v, want %v", err, ErrEmptyPrefix)
+	}
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// pkgsReference matches the references to packages in the type or default of an option,
+// e.g. "pkgs.nginx" or "pkgs.python3Packages.django".
+var pkgsReference = regexp.MustCompile(`\bpkgs\.([A-Za-z0-9_'-]+(?:\.[A-Za-z0-9_'-]+)*)`)
+
+// moduleNamespaces are the namespaces of the modules configuring a program, by its name.
+var moduleNamespaces = []string{"services", "programs"}
+
+// OptionRef is an option, or a module of options, configuring a package.
+type OptionRef struct {
+	Source string `json:"source"` // source of options, e.g. nixos or home-manager
+	Key    string `json:"key"`    // e.g. "services.nginx" for a module, or an option key
+	Module bool   `json:"module"` // Key is the path of a module rather than an option
+}
+
+// PackageDetail is a package along with the options configuring it.
+type PackageDetail struct {
+	Package
+	Options []OptionRef `json:"options"`
+}
+
+// OptionDetail is an option along with the nixpkgs packages it configures.
+type OptionDetail struct {
+	Option
+	Packages []string `json:"packages"`
+}
+
+// crossRefs links the nixpkgs packages and the options configuring them.
+// It is built once when the index is loaded.
+type crossRefs struct {
+	options  map[string][]OptionRef // nixpkgs package -> options
+	packages map[string][]string    // section/option key -> nixpkgs packages
+}
+
+// buildCrossRefs links every nixpkgs package to the modules named after it
+// ("services.<name>", "programs.<name>") and to the options referencing it
+// in their type or default ("pkgs.<name>").
+func buildCrossRefs(index Index) *crossRefs {
+	refs := &crossRefs{
+		options:  map[string][]OptionRef{},
+		packages: map[string][]string{},
+	}
+
+	link := func(pkg string, ref OptionRef, option string) {
+		if !slices.Contains(refs.options[pkg], ref) {
+			refs.options[pkg] = append(refs.options[pkg], ref)
+		}
+		key := ref.Source + "/" + option
+		if !slices.Contains(refs.packages[key], pkg) {
+			refs.packages[key] = append(refs.packages[key], pkg)
+		}
+	}
+
+	for section, options := range index.Options {
+		for key, opt := range options {
+			segments := splitOptionPath(key)
+			module := ""
+			if len(segments) >= 2 && slices.Contains(moduleNamespaces, segments[0]) {
+				if _, ok := index.Packages["nixpkgs"][segments[1]]; ok {
+					module = segments[1]
+					link(module, OptionRef{Source: section, Key: segments[0] + "." + segments[1], Module: true}, key)
+				}
+			}
+
+			for _, match := range pkgsReference.FindAllStringSubmatch(opt.Type+"\n"+opt.Default, -1) {
+				pkg, ok := nixpkgsAttribute(index, match[1])
+				// The options of a module referencing its own package are covered by the module.
+				if !ok || pkg == module {
+					continue
+				}
+				link(pkg, OptionRef{Source: section, Key: key}, key)
+			}
+		}
+	}
+
+	for pkg := range refs.options {
+		slices.SortFunc(refs.options[pkg], func(a, b OptionRef) int {
+			if a.Module != b.Module {
+				if a.Module {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a.Source+"/"+a.Key, b.Source+"/"+b.Key)
+		})
+	}
+	for key := range refs.packages {
+		slices.Sort(refs.packages[key])
+	}
+	return refs
+}
+
+// nixpkgsAttribute returns the longest prefix of an attribute path that is a nixpkgs package,
+// e.g. "python3Packages.django" for "python3Packages.django.override".
+func nixpkgsAttribute(index Index, path string) (string, bool) {
+	for {
+		if _, ok := index.Packages["nixpkgs"][path]; ok {
+			return path, true
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return "", false
+		}
+		path = path[:i]
+	}
+}
+
+// PackageDetail returns a package of a source with the options configuring it:
+// its modules first, then the options referencing it. Only nixpkgs packages
+// are linked to options.
+func (index Index) PackageDetail(section, key string) (PackageDetail, bool) {
+	pkg, ok := index.packageOf(&document{section: section, key: key})
+	if !ok {
+		return PackageDetail{}, false
+	}
+	refs := index.references()
+	options := []OptionRef{}
+	if section == "nixpkgs" && refs.options[key] != nil {
+		options = refs.options[key]
+	}
+	return PackageDetail{Package: pkg, Options: options}, true
+}
+
+// OptionDetail returns an option of a source with the nixpkgs packages it configures.
+func (index Index) OptionDetail(section, key string) (OptionDetail, bool) {
+	opt, ok := index.optionOf(&document{section: section, key: key})
+	if !ok {
+		return OptionDetail{}, false
+	}
+	refs := index.references()
+	packages := refs.packages[section+"/"+key]
+	if packages == nil {
+		packages = []string{}
+	}
+	return OptionDetail{Option: opt, Packages: packages}, true
+}
@@ -0,0 +1,67 @@
+package indexer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// MaxPerPage is the maximum number of results returned by a single page.
+const MaxPerPage = 100
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrStaleCursor   = errors.New("cursor refers to an index generation that no longer exists, restart the search")
+	ErrCursorQuery   = errors.New("cursor was issued for a different query")
+)
+
+// Cursor is the position of a page in the results of a search.
+// It is tied to the generation of the index and to the query it was issued for,
+// so that pages of a search never mix results of two versions of the index.
+type Cursor struct {
+	Generation string `json:"g"`
+	Query      string `json:"q"` // fingerprint of the query, see Fingerprint
+	Offset     int    `json:"o"`
+}
+
+// Generation returns the version of the index, as reported by nix-json, with
+// the hash of its content. It changes every time the index is reloaded with
+// new data, even under the same version, e.g. when local sources change.
+func (index Index) Generation() string {
+	return index.Info["version"] + "-" + strings.TrimPrefix(index.Info["hash"], "sha256-")
+}
+
+// Fingerprint returns a short digest identifying a search request from its parameters.
+func Fingerprint(params ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(params, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Encode returns the opaque representation of the cursor.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses an opaque cursor, and checks that it belongs to the
+// given generation of the index and query fingerprint.
+func DecodeCursor(s, generation, query string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.Offset < 0 {
+		return c, ErrInvalidCursor
+	}
+	if c.Generation != generation {
+		return c, ErrStaleCursor
+	}
+	if c.Query != query {
+		return c, ErrCursorQuery
+	}
+	return c, nil
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	query := Fingerprint("firefox", "relevance")
+	valid := Cursor{Generation: "g1", Query: query, Offset: 40}
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   error
+	}{
+		{"valid", valid.Encode(), nil},
+		{"first page", Cursor{Generation: "g1", Query: query}.Encode(), nil},
+		{"empty", "", ErrInvalidCursor},
+		{"not base64", "not a cursor!", ErrInvalidCursor},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"g":"g1"}`)), ErrInvalidCursor},
+		{"not json", raw("firefox"), ErrInvalidCursor},
+		{"wrong types", raw(`{"g":1,"q":"x","o":"2"}`), ErrInvalidCursor},
+		{"negative offset", Cursor{Generation: "g1", Query: query, Offset: -20}.Encode(), ErrInvalidCursor},
+		{"other generation", Cursor{Generation: "g0", Query: query, Offset: 40}.Encode(), ErrStaleCursor},
+		{"other query", Cursor{Generation: "g1", Query: Fingerprint("chromium", "relevance"), Offset: 40}.Encode(), ErrCursorQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := DecodeCursor(tt.cursor, "g1", query)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DecodeCursor() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && c.Offset < 0 {
+				t.Errorf("DecodeCursor() offset = %d", c.Offset)
+			}
+		})
+	}
+
+	c, err := DecodeCursor(valid.Encode(), "g1", query)
+	if err != nil || c != valid {
+		t.Errorf("DecodeCursor(Encode()) = %+v, %v, want %+v", c, err, valid)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("a", "b") != Fingerprint("a", "b") {
+		t.Error("Fingerprint is not deterministic")
+	}
+	if Fingerprint("ab", "c") == Fingerprint("a", "bc") {
+		t.Error("Fingerprint doesn't separate its parameters")
+	}
+}
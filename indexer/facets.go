@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FacetNames are the facets of search results, in the order they are computed.
+// They are named after the qualifiers of the same values, see ParseQuery.
+var FacetNames = []string{
+	"source",     // nixpkgs, nur, nixos, home-manager, darwin
+	"kind",       // package or option
+	"license",    // SPDX identifier, or full name
+	"platform",   // simplified platform, e.g. linux
+	"broken",     // true or false, packages only
+	"vulnerable", // true or false, packages only
+	"maintainer", // GitHub handle, or name
+	"type",       // option type, options only
+}
+
+// maxFacetBuckets is the maximum number of buckets of a facet.
+const maxFacetBuckets = 20
+
+// Facets are the buckets of each facet of a set of results.
+type Facets map[string][]FacetBucket
+
+// FacetBucket is a value of a facet, with the number of results having it.
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// facetValues returns the values of a facet for a result.
+func (index Index) facetValues(facet string, item PackageOrOption) []string {
+	d := &document{section: item.Section, key: item.Key}
+	switch facet {
+	case "source":
+		return []string{item.Section}
+	case "kind":
+		return []string{item.Type}
+	case "type":
+		if opt, ok := index.optionOf(d); ok && opt.Type != "" {
+			return []string{opt.Type}
+		}
+		return nil
+	}
+
+	pkg, ok := index.packageOf(d)
+	if !ok {
+		return nil
+	}
+	values := []string{}
+	switch facet {
+	case "license":
+		for _, l := range pkg.Licenses {
+			if l.SpdxID != "" {
+				values = append(values, l.SpdxID)
+			} else if l.FullName != "" {
+				values = append(values, l.FullName)
+			}
+		}
+	case "platform":
+		values = pkg.PlatformsSimplify
+	case "broken":
+		values = append(values, strconv.FormatBool(pkg.Broken))
+	case "vulnerable":
+		values = append(values, strconv.FormatBool(pkg.Vulnerable))
+	case "maintainer":
+		for _, m := range pkg.Maintainers {
+			if m.GitHub != "" {
+				values = append(values, m.GitHub)
+			} else if m.Name != "" {
+				values = append(values, m.Name)
+			}
+		}
+	}
+	return values
+}
+
+// Facets counts the values of every facet over results.
+// Each facet has at most maxFacetBuckets buckets, most common first.
+func (index Index) Facets(items []PackageOrOption) Facets {
+	facets := Facets{}
+	for _, facet := range FacetNames {
+		counts := map[string]int{}
+		for _, item := range items {
+			for _, value := range index.facetValues(facet, item) {
+				counts[value]++
+			}
+		}
+		buckets := make([]FacetBucket, 0, len(counts))
+		for value, count := range counts {
+			buckets = append(buckets, FacetBucket{Value: value, Count: count})
+		}
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count != buckets[j].Count {
+				return buckets[i].Count > buckets[j].Count
+			}
+			return buckets[i].Value < buckets[j].Value
+		})
+		if len(buckets) > maxFacetBuckets {
+			buckets = buckets[:maxFacetBuckets]
+		}
+		facets[facet] = buckets
+	}
+	return facets
+}
+
+// FilterFacets keeps the results having, for every filtered facet, one of the
+// given values (case insensitively).
+func (index Index) FilterFacets(
+	items []PackageOrOption,
+	filters map[string][]string,
+) ([]PackageOrOption, error) {
+	for facet, wanted := range filters {
+		if !slices.Contains(FacetNames, facet) {
+			return nil, fmt.Errorf("unknown facet %q", facet)
+		}
+		if facet == "kind" {
+			for _, w := range wanted {
+				if !strings.EqualFold(w, string(KindPackage)) && !strings.EqualFold(w, string(KindOption)) {
+					return nil, fmt.Errorf("unknown kind %q, expected package or option", w)
+				}
+			}
+		}
+	}
+
+	res := []PackageOrOption{}
+	for _, item := range items {
+		keep := true
+		for facet, wanted := range filters {
+			values := index.facetValues(facet, item)
+			if !slices.ContainsFunc(values, func(v string) bool {
+				return slices.ContainsFunc(wanted, func(w string) bool { return strings.EqualFold(v, w) })
+			}) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,49 @@
+package indexer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilterFacets(t *testing.T) {
+	index := NewIndex(nil, map[string]Packages{
+		"nixpkgs": {"nginx": {Source: "nixpkgs", Description: "Reverse proxy and lightweight webserver"}},
+	}, map[string]Options{
+		"nixos": {
+			"services.nginx.enable":  {Source: "nixpkgs", Type: "boolean", Description: "Whether to enable Nginx Web Server."},
+			"services.nginx.package": {Source: "nixpkgs", Type: "package", Description: "Nginx package to use."},
+		},
+	})
+	results := index.Search("nginx")
+
+	tests := []struct {
+		filters map[string][]string
+		want    []string
+	}{
+		{map[string][]string{"kind": {"package"}}, []string{"nginx"}},
+		{map[string][]string{"kind": {"Option"}}, []string{"services.nginx.enable", "services.nginx.package"}},
+		{map[string][]string{"type": {"boolean"}}, []string{"services.nginx.enable"}},
+		{map[string][]string{"type": {"package"}}, []string{"services.nginx.package"}},
+		{map[string][]string{"kind": {"package"}, "type": {"boolean"}}, []string{}},
+		{map[string][]string{"source": {"nixos"}, "type": {"boolean", "package"}}, []string{"services.nginx.enable", "services.nginx.package"}},
+	}
+	for _, tt := range tests {
+		filtered, err := index.FilterFacets(results, tt.filters)
+		if err != nil {
+			t.Errorf("FilterFacets(%v) returned error: %v", tt.filters, err)
+			continue
+		}
+		if got := sortedKeys(filtered); !slices.Equal(got, tt.want) {
+			t.Errorf("FilterFacets(%v) = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+
+	for _, filters := range []map[string][]string{
+		{"optionType": {"boolean"}},
+		{"kind": {"boolean"}},
+	} {
+		if _, err := index.FilterFacets(results, filters); err == nil {
+			t.Errorf("FilterFacets(%v) returned no error", filters)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// AllFields selects every field of the records in SelectFields.
+const AllFields = "all"
+
+var (
+	// PackageFields are the fields of a package record, by their JSON name.
+	PackageFields = jsonFields(reflect.TypeFor[Package]())
+	// OptionFields are the fields of an option record, by their JSON name.
+	OptionFields = jsonFields(reflect.TypeFor[Option]())
+)
+
+// jsonFields returns the JSON names of the fields of a struct.
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// ParseFields parses a comma separated list of record fields, e.g. "version,licenses".
+// Fields may belong to packages, options or both, "all" selects all of them.
+func ParseFields(s string) ([]string, error) {
+	fields := []string{}
+	for field := range strings.SplitSeq(s, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+			continue
+		case field == AllFields:
+		case !slices.Contains(PackageFields, field) && !slices.Contains(OptionFields, field):
+			known := slices.Clone(PackageFields)
+			for _, f := range OptionFields {
+				if !slices.Contains(known, f) {
+					known = append(known, f)
+				}
+			}
+			return nil, fmt.Errorf("unknown field %q, expected one of %s or %s",
+				field, strings.Join(known, ", "), AllFields)
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// SelectFields sets the Record of results, and of their alternates, to the given
+// fields of the package or option behind them. Fields that don't apply to a
+// result (e.g. "version" for an option) are left out.
+func (index Index) SelectFields(items []PackageOrOption, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	all := slices.Contains(fields, AllFields)
+
+	for i := range items {
+		var record reflect.Value
+		d := &document{section: items[i].Section, key: items[i].Key}
+		if pkg, ok := index.packageOf(d); ok {
+			record = reflect.ValueOf(pkg)
+		} else if opt, ok := index.optionOf(d); ok {
+			record = reflect.ValueOf(opt)
+		} else {
+			continue
+		}
+
+		items[i].Record = map[string]any{}
+		for j := range record.NumField() {
+			name, _, _ := strings.Cut(record.Type().Field(j).Tag.Get("json"), ",")
+			if name != "" && name != "-" && (all || slices.Contains(fields, name)) {
+				items[i].Record[name] = record.Field(j).Interface()
+			}
+		}
+		index.SelectFields(items[i].Alternates, fields)
+	}
+}
@@ -0,0 +1,140 @@
+package indexer
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the maximum number of suggestions returned by Suggest.
+const maxSuggestions = 5
+
+// maxEdits returns the number of edits tolerated when fuzzy matching a term.
+func maxEdits(term string) int {
+	switch n := len(term); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of two
+// adjacent characters needed to turn a into b.
+// It gives up and returns max+1 as soon as the distance is known to exceed max.
+func editDistance(a, b string, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// closestTokens returns the tokens of the vocabulary closest to a term,
+// within maxEdits(term) edits.
+func (s *searchIndex) closestTokens(term string) []tokenMatch {
+	closest := []tokenMatch{}
+	edits := maxEdits(term)
+	if edits == 0 {
+		return closest
+	}
+	for _, token := range s.vocab {
+		d := editDistance(term, token, edits)
+		if d < edits {
+			edits = d
+			closest = closest[:0]
+		}
+		if d == edits {
+			closest = append(closest, tokenMatch{token: token, kind: matchFuzzy})
+		}
+	}
+	for i := range closest {
+		closest[i].weight = matchWeights[matchFuzzy] / float64(edits)
+	}
+	return closest
+}
+
+// Suggest returns close spellings of the keys of the index for the search terms of a query.
+// It is meant to be used when a query has no results: keys are compared to each term
+// on the same number of path segments, so "servces.nginx" suggests "services.nginx".
+func (index Index) Suggest(q Query) []string {
+	s := index.inverted()
+
+	type suggestion struct {
+		key      string
+		distance int
+		count    int
+	}
+	found := map[string]*suggestion{}
+
+	for _, c := range q.Terms() {
+		term := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(c.Value), "^"), "$")
+		bound := 1 + len(term)/3
+		segments := strings.Count(term, ".") + 1
+		for i := range s.docs {
+			candidate := s.docs[i].lower
+			if parts := strings.SplitN(candidate, ".", segments+1); len(parts) > segments {
+				candidate = strings.Join(parts[:segments], ".")
+			}
+			if candidate == term {
+				continue
+			}
+			d := editDistance(term, candidate, bound)
+			if d > bound {
+				continue
+			}
+			if sug, ok := found[candidate]; ok {
+				sug.count++
+				sug.distance = min(sug.distance, d)
+			} else {
+				found[candidate] = &suggestion{key: candidate, distance: d, count: 1}
+			}
+		}
+	}
+
+	suggestions := make([]*suggestion, 0, len(found))
+	for _, sug := range found {
+		suggestions = append(suggestions, sug)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		if suggestions[i].count != suggestions[j].count {
+			return suggestions[i].count > suggestions[j].count
+		}
+		return suggestions[i].key < suggestions[j].key
+	})
+
+	res := []string{}
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		res = append(res, suggestions[i].key)
+	}
+	return res
+}
@@ -0,0 +1,175 @@
+package indexer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Span is a highlighted part of a text, as byte offsets.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Highlights are the parts of the key and the description of a result
+// matching the query.
+type Highlights struct {
+	Key         []Span `json:"key"`
+	Description []Span `json:"description"`
+}
+
+// termHighlighter finds the parts of a text matching a search term.
+type termHighlighter struct {
+	term    searchTerm
+	fuzzy   bool
+	matches []map[string]tokenMatch // per token of the term, by vocabulary token
+}
+
+// Highlight sets the highlights of results of a query, using the same
+// matching as SearchQuery.
+func (index Index) Highlight(q Query, items []PackageOrOption) {
+	s := index.inverted()
+
+	highlighters := []termHighlighter{}
+	for _, c := range q.Terms() {
+		h := termHighlighter{term: newSearchTerm(c)}
+		resolved, fuzzy := s.resolve(h.term)
+		h.fuzzy = fuzzy
+		for _, matches := range resolved {
+			byToken := map[string]tokenMatch{}
+			for _, m := range matches {
+				byToken[m.token] = m
+			}
+			h.matches = append(h.matches, byToken)
+		}
+		highlighters = append(highlighters, h)
+	}
+
+	for i := range items {
+		hl := &Highlights{Key: []Span{}, Description: []Span{}}
+		for _, h := range highlighters {
+			hl.Key = append(hl.Key, h.spans(items[i].Key, fieldKey)...)
+			if !h.term.onlyOnKey() {
+				hl.Description = append(hl.Description, h.spans(items[i].Description, fieldDescription)...)
+			}
+		}
+		hl.Key = mergeSpans(hl.Key)
+		hl.Description = mergeSpans(hl.Description)
+		items[i].Highlights = hl
+	}
+}
+
+// HighlightRegex sets the highlights of results of SearchRegex:
+// the parts of the keys matching the pattern.
+func HighlightRegex(re *regexp.Regexp, items []PackageOrOption) {
+	for i := range items {
+		hl := &Highlights{Key: []Span{}, Description: []Span{}}
+		for _, loc := range re.FindAllStringIndex(items[i].Key, -1) {
+			if loc[0] != loc[1] {
+				hl.Key = append(hl.Key, Span{Start: loc[0], End: loc[1]})
+			}
+		}
+		items[i].Highlights = hl
+	}
+}
+
+// spans returns the parts of a text matching the term.
+func (h termHighlighter) spans(text string, field uint8) []Span {
+	lower := strings.ToLower(text)
+
+	// Anchored and adjacent terms highlight their whole occurrence.
+	if !h.fuzzy && len(lower) == len(text) {
+		switch {
+		case h.term.start && h.term.end:
+			if lower == h.term.text {
+				return []Span{{Start: 0, End: len(text)}}
+			}
+			return nil
+		case h.term.start:
+			if strings.HasPrefix(lower, h.term.text) {
+				return []Span{{Start: 0, End: len(h.term.text)}}
+			}
+			return nil
+		case h.term.end:
+			if strings.HasSuffix(lower, h.term.text) {
+				return []Span{{Start: len(text) - len(h.term.text), End: len(text)}}
+			}
+			return nil
+		case h.term.adjacent():
+			spans := []Span{}
+			for offset := 0; ; {
+				i := strings.Index(lower[offset:], h.term.text)
+				if i < 0 {
+					break
+				}
+				start := offset + i
+				spans = append(spans, Span{Start: start, End: start + len(h.term.text)})
+				offset = start + len(h.term.text)
+			}
+			return spans
+		}
+	}
+
+	spans := []Span{}
+	for _, t := range tokenSpans(text) {
+		token := strings.ToLower(text[t.Start:t.End])
+		for i, byToken := range h.matches {
+			m, ok := byToken[token]
+			if !ok || !m.allowedIn(field) {
+				continue
+			}
+			kind := m.kind
+			if len(token) != t.End-t.Start {
+				// Lowercasing changed the length of the token, only highlight it whole.
+				kind = matchExact
+			}
+			switch kind {
+			case matchPrefix:
+				spans = append(spans, Span{Start: t.Start, End: t.Start + len(h.term.tokens[i])})
+			case matchSubstring:
+				start := t.Start + strings.Index(token, h.term.tokens[i])
+				spans = append(spans, Span{Start: start, End: start + len(h.term.tokens[i])})
+			default:
+				spans = append(spans, t)
+			}
+		}
+	}
+	return spans
+}
+
+// tokenSpans returns the position of the tokens of a text, as split by tokenize.
+func tokenSpans(text string) []Span {
+	spans := []Span{}
+	start := -1
+	for i, r := range text {
+		inToken := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inToken && start < 0 {
+			start = i
+		} else if !inToken && start >= 0 {
+			spans = append(spans, Span{Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, Span{Start: start, End: len(text)})
+	}
+	return spans
+}
+
+// mergeSpans sorts spans and merges the overlapping ones.
+func mergeSpans(spans []Span) []Span {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Start < spans[j].Start
+	})
+	merged := []Span{}
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, span.End)
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -1,20 +1,19 @@
 package indexer
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/anotherhadi/search-nixos-api/indexer/darwin"
-	"github.com/anotherhadi/search-nixos-api/indexer/homemanager"
-	"github.com/anotherhadi/search-nixos-api/indexer/nixos"
-	"github.com/anotherhadi/search-nixos-api/indexer/nixpkgs"
-	"github.com/anotherhadi/search-nixos-api/indexer/nur"
 )
 
 func simplifyPlatform(pkgs Package) Package {
@@ -34,307 +33,244 @@ func simplifyPlatform(pkgs Package) Package {
 	return pkgs
 }
 
-func dlNixos() Options {
-	file := "nixos.json"
-	log.Println("Downloading " + file + "...")
-	jsonObject := map[string]nixos.Package{}
-	err := downloadAndReadRelease(file, &jsonObject)
+// fetchSource downloads and normalizes the data of a source, returning the raw data along.
+func fetchSource(source Source, channel Channel) (Data, []byte, error) {
+	log.Println("Downloading " + source.Name() + "...")
+	raw, err := source.Fetch(channel)
 	if err != nil {
-		log.Println(err)
+		return Data{}, nil, fmt.Errorf("downloading %s: %w", source.Name(), err)
 	}
-	log.Println("Downloaded " + file + " successfully")
-	log.Println("Parsing " + file + "...")
-	options := Options{}
-	for k, v := range jsonObject {
-		opt := Option{
-			Source:       "nixpkgs",
-			Type:         v.Type,
-			Description:  v.Description,
-			Declarations: []string{},
-			Default:      v.Default.Text,
-			Example:      v.Example.Text,
-		}
-		for _, d := range v.Declarations {
-			opt.Declarations = append(
-				opt.Declarations,
-				"https://github.com/NixOS/nixpkgs/blob/nixos-unstable/"+d,
-			)
-		}
-		options[k] = opt
+	log.Println("Parsing " + source.Name() + "...")
+	data, err := source.Normalize(raw, channel)
+	if err != nil {
+		return Data{}, nil, fmt.Errorf("parsing %s: %w", source.Name(), err)
 	}
-	log.Println("Parsed " + file + " successfully")
-	return options
+	log.Println("Parsed " + source.Name() + " successfully")
+	return data, raw, nil
 }
 
-func dlHomemanager() Options {
-	file := "home-manager.json"
-	log.Println("Downloading " + file + "...")
-	jsonObject := map[string]homemanager.Package{}
-	err := downloadAndReadRelease(file, &jsonObject)
+// setHealth records the health of a source in the info of the index: its
+// status, the error of its last download, and the time and the release version
+// of its last successful one.
+func (index *Index) setHealth(source Source, status SourceStatus, err error, updated, version string) {
+	index.Info[sourceInfo(source.Name(), "status")] = string(status)
+	index.Info[sourceInfo(source.Name(), "updated")] = updated
+	index.Info[sourceInfo(source.Name(), "version")] = version
 	if err != nil {
-		log.Println(err)
+		index.Info[sourceInfo(source.Name(), "error")] = err.Error()
+	} else {
+		delete(index.Info, sourceInfo(source.Name(), "error"))
 	}
-	log.Println("Downloaded " + file + " successfully")
-	log.Println("Parsing " + file + "...")
-	options := Options{}
-	for k, v := range jsonObject {
-		opt := Option{
-			Source:       "home-manager",
-			Type:         v.Type,
-			Description:  v.Description,
-			Declarations: []string{},
-			Default:      v.Default.Text,
-			Example:      v.Example.Text,
-		}
-		for _, d := range v.Declarations {
-			opt.Declarations = append(opt.Declarations, d.URL)
-		}
-		options[k] = opt
+	if status != StatusOK {
+		index.Info["status"] = "degraded"
 	}
-	log.Println("Parsed " + file + " successfully")
-	return options
 }
 
-func dlDarwin() Options {
-	file := "darwin.json"
-	log.Println("Downloading " + file + "...")
-	jsonObject := darwin.Darwin{}
-	err := downloadAndReadRelease(file, &jsonObject)
-	if err != nil {
-		log.Println(err)
-	}
-	log.Println("Downloaded " + file + " successfully")
-	log.Println("Parsing " + file + "...")
-	options := Options{}
-	for k, v := range jsonObject.Packages {
-		opt := Option{
-			Source:       "darwin",
-			Type:         v.Type,
-			Description:  v.Description,
-			Declarations: v.DeclaredBy,
-			Default:      v.Default,
-			Example:      v.Example,
-		}
-		options[k] = opt
-	}
-	log.Println("Parsed " + file + " successfully")
-	return options
+// DownloadReleases downloads the release of a channel (its pinned tag, or the
+// latest one) and saves the index to path.
+// A source that fails keeps its data of the previous index at path, and its
+// error and the version its data comes from are recorded in the info of the
+// index. The index is left untouched if the release can't be downloaded, if
+// every source fails, or if a source fails and the previous index is of
+// another tag, so that a pinned release is never mixed with another one.
+func DownloadReleases(path string, channel Channel) error {
+	return downloadReleases(path, channel, false)
+}
+
+// DownloadReleasesStrict is like DownloadReleases, but leaves the index
+// untouched as soon as a source fails.
+func DownloadReleasesStrict(path string, channel Channel) error {
+	return downloadReleases(path, channel, true)
 }
 
-func dlNixpkgs() Packages {
-	file := "nixpkgs.json"
-	log.Println("Downloading " + file + "...")
-	jsonObject := nixpkgs.Nixpkgs{}
-	err := downloadAndReadRelease(file, &jsonObject)
+func downloadReleases(path string, channel Channel, strict bool) error {
+	log.Println("Downloading releases of the", channel.Name, "channel at", channel.release()+"...")
+	// The version comes first, so that a missing release fails early.
+	log.Println("Downloading version")
+	content, err := readRelease(channel, "version")
 	if err != nil {
 		log.Println(err)
+		return err
 	}
-	log.Println("Downloaded " + file + " successfully")
-	log.Println("Parsing " + file + "...")
-	packages := Packages{}
-	for k, v := range jsonObject.Packages {
-		pkg := Package{
-			Source:               "nixpkgs",
-			Name:                 v.Meta.Name,
-			Version:              v.Version,
-			Description:          v.Meta.Description,
-			LongDescription:      v.Meta.LongDescription,
-			MainProgram:          v.Meta.MainProgram,
-			Licenses:             []License{},
-			Maintainers:          []Maintainer{},
-			KnownVulnerabilities: []string{},
-			Broken:               v.Meta.Broken,
-			Unfree:               v.Meta.Unfree,
-			Position:             v.Meta.Position,
-			PositionUrl:          v.Meta.Position,
-		}
+	// The hash of the downloaded files identifies the content of the release.
+	hash := sha256.New()
+	hash.Write(content)
 
-		pkg.PositionUrl = "https://github.com/NixOS/nixpkgs/blob/nixos-unstable/" + strings.Replace(
-			v.Meta.Position,
-			":",
-			"#L",
-			1,
-		)
-		if v.Meta.KnownVulnerabilities != nil && len(v.Meta.KnownVulnerabilities) != 0 {
-			pkg.KnownVulnerabilities = v.Meta.KnownVulnerabilities
-			pkg.Vulnerable = true
-		} else {
-			pkg.KnownVulnerabilities = []string{}
-		}
-		if v.Meta.Homepages != nil {
-			pkg.Homepages = v.Meta.Homepages
-		} else {
-			pkg.Homepages = []string{}
+	now := time.Now().Format(time.RFC3339)
+	index := NewIndex(map[string]string{
+		"version":      strings.TrimSpace(string(content)),
+		"channel":      channel.Name,
+		"url":          redactURL(channel.URL),
+		"commit":       channel.Commit,
+		"tag":          channel.release(),
+		"status":       "ok",
+		"last-updated": now,
+	}, nil, nil)
+	var previous *Index
+	errs := []error{}
+	for _, source := range sources {
+		data, raw, err := fetchSource(source, channel)
+		if err == nil {
+			index.set(source, data)
+			index.setHealth(source, StatusOK, nil, now, index.Info["version"])
+			hash.Write([]byte("\x00" + source.Name() + "\x00"))
+			hash.Write(raw)
+			continue
 		}
-		if v.Meta.Platforms != nil {
-			pkg.Platforms = v.Meta.Platforms
-		} else {
-			pkg.Platforms = []string{}
-		}
-		for _, l := range v.Meta.Licenses {
-			pkg.Licenses = append(pkg.Licenses, License{
-				FullName: l.FullName,
-				Free:     l.Free,
-				SpdxID:   l.SpdxID,
-			})
-		}
-		for _, m := range v.Meta.Maintainers {
-			pkg.Maintainers = append(pkg.Maintainers, Maintainer{
-				Name:     m.Name,
-				Email:    m.Email,
-				GitHub:   m.GitHub,
-				GithubId: m.GithubId,
-			})
-		}
-		packages[k] = simplifyPlatform(pkg)
-	}
-	log.Println("Parsed " + file + " successfully")
-	return packages
-}
-
-func dlNur() Packages {
-	file := "nur.json"
-	log.Println("Downloading " + file + "...")
-	jsonObject := nur.Nur{}
-	err := downloadAndReadRelease(file, &jsonObject)
-	if err != nil {
 		log.Println(err)
-	}
-	log.Println("Downloaded " + file + " successfully")
-	log.Println("Parsing " + file + "...")
-	packages := Packages{}
-	for k, v := range jsonObject.Packages {
-		pkg := Package{
-			Source:               "nur",
-			Name:                 v.Meta.Name,
-			Version:              v.Version,
-			Description:          v.Meta.Description,
-			LongDescription:      v.Meta.LongDescription,
-			MainProgram:          v.Meta.MainProgram,
-			Licenses:             []License{},
-			Maintainers:          []Maintainer{},
-			KnownVulnerabilities: []string{},
-			Broken:               v.Meta.Broken,
-			Unfree:               v.Meta.Unfree,
-			Position:             v.Meta.Position,
-			PositionUrl:          v.Meta.Position,
+		if strict {
+			return err
 		}
-		if v.Meta.KnownVulnerabilities != nil && len(v.Meta.KnownVulnerabilities) != 0 {
-			pkg.KnownVulnerabilities = v.Meta.KnownVulnerabilities
-			pkg.Vulnerable = true
-		} else {
-			pkg.KnownVulnerabilities = []string{}
+		errs = append(errs, err)
+
+		// Fall back to the data of the previous index
+		if previous == nil {
+			previous = &Index{}
+			if DoesFileExist(path) {
+				var readErr error
+				if *previous, readErr = readIndex(path); readErr != nil {
+					log.Println("Could not read the previous index:", readErr)
+				}
+			}
 		}
-		if v.Meta.Homepages != nil {
-			pkg.Homepages = v.Meta.Homepages
-		} else {
-			pkg.Homepages = []string{}
+		if !previous.has(source) || previous.Info[sourceInfo(source.Name(), "status")] == string(StatusFailed) {
+			index.set(source, Data{})
+			index.setHealth(source, StatusFailed, err, "", "")
+			continue
 		}
-		if v.Meta.Platforms != nil {
-			pkg.Platforms = v.Meta.Platforms
-		} else {
-			pkg.Platforms = []string{}
+		if tag := previous.release(); tag != channel.release() {
+			err = fmt.Errorf("%w, and the previous index is of release %s, keeping the index", err, tag)
+			log.Println(err)
+			return err
 		}
-		for _, l := range v.Meta.Licenses {
-			pkg.Licenses = append(pkg.Licenses, License{
-				FullName: l.FullName,
-				Free:     l.Free,
-				SpdxID:   l.SpdxID,
-			})
+		log.Println("Keeping the previous data of", source.Name())
+		updated := previous.Info[sourceInfo(source.Name(), "updated")]
+		if updated == "" {
+			updated = previous.Info["last-updated"]
 		}
-		for _, m := range v.Meta.Maintainers {
-			pkg.Maintainers = append(pkg.Maintainers, Maintainer{
-				Name:     m.Name,
-				Email:    m.Email,
-				GitHub:   m.GitHub,
-				GithubId: m.GithubId,
-			})
+		version := previous.Info[sourceInfo(source.Name(), "version")]
+		if version == "" {
+			version = previous.Info["version"]
 		}
-		packages[k] = simplifyPlatform(pkg)
-	}
-	log.Println("Parsed " + file + " successfully")
-	return packages
-}
-
-func DownloadReleases(path string) {
-	log.Println("Downloading releases...")
-	index := Index{}
-
-	index.Darwin = dlDarwin()
-	index.Nixpkgs = dlNixpkgs()
-	index.Nur = dlNur()
-	index.Nixos = dlNixos()
-	index.Homemanager = dlHomemanager()
-
-	log.Println("Downloading version")
-	rc, err := downloadRelease("version")
-	if err != nil {
-		log.Println(err)
-		return
+		data = Data{Packages: previous.Packages[source.Name()], Options: previous.Options[source.Name()]}
+		index.set(source, data)
+		index.setHealth(source, StatusStale, err, updated, version)
+		raw, _ = json.Marshal(data)
+		hash.Write([]byte("\x00" + source.Name() + "\x00"))
+		hash.Write(raw)
 	}
-	defer rc.Close()
-	content, err := io.ReadAll(rc)
-	if err != nil {
-		log.Println(err)
-		return
+	if len(errs) == len(sources) {
+		err := errors.Join(errs...)
+		log.Println("Every source failed, keeping the index:", err)
+		return err
 	}
 
 	log.Println("Writing index.json...")
-	index.Info = map[string]string{
-		"version":            string(content),
-		"last-updated":       time.Now().Format(time.RFC3339),
-		"nixos-length":       strconv.Itoa(len(index.Nixos)),
-		"nixpkgs-length":     strconv.Itoa(len(index.Nixpkgs)),
-		"nur-length":         strconv.Itoa(len(index.Nur)),
-		"darwin-length":      strconv.Itoa(len(index.Darwin)),
-		"homemanager-length": strconv.Itoa(len(index.Homemanager)),
+	index.Info["hash"] = "sha256-" + hex.EncodeToString(hash.Sum(nil))
+	for _, source := range sources {
+		index.Info[lengthInfo(source.Name())] = strconv.Itoa(index.Len(source.Name()))
 	}
 
-	indexFile, err := os.Create(path)
+	content, err = json.MarshalIndent(index, "", "  ")
 	if err != nil {
 		log.Println(err)
-		return
+		return err
 	}
-	defer indexFile.Close()
-	content, err = json.MarshalIndent(index, "", "  ")
-	if err != nil {
+	// Replace the index at once, so that a failed write doesn't leave it truncated
+	if err := os.WriteFile(path+".tmp", content, 0o644); err != nil {
 		log.Println(err)
-		return
+		return err
 	}
-	_, err = indexFile.Write(content)
-	if err != nil {
+	if err := os.Rename(path+".tmp", path); err != nil {
 		log.Println(err)
-		return
+		return err
 	}
 	log.Println("Index downloaded and saved to", path)
 	log.Println("Info:", index.Info)
 	log.Println("Index file size:", len(content), "bytes")
+	return nil
 }
 
-func GetIndex(path string) (index Index) {
-	if !DoesFileExist(path) {
-		DownloadReleases(path)
+// release returns the release tag the index was downloaded from. Indexes
+// downloaded before releases could be pinned are of the latest release.
+func (index Index) release() string {
+	if tag := index.Info["tag"]; tag != "" {
+		return tag
 	}
+	return "latest"
+}
 
+// readIndex reads the index saved at path.
+func readIndex(path string) (index Index, err error) {
 	log.Println("Opening index.json...")
 	indexFile, err := os.Open(path)
 	if err != nil {
-		log.Println(err)
-		return
+		return index, err
 	}
 	defer indexFile.Close()
 	content, err := io.ReadAll(indexFile)
 	if err != nil {
-		log.Println(err)
-		return
+		return index, err
 	}
 	log.Println("Parsing index.json...")
 	err = json.Unmarshal(content, &index)
+	return index, err
+}
+
+func GetIndex(path string, channel Channel) (index Index) {
+	if !DoesFileExist(path) {
+		DownloadReleases(path, channel)
+	}
+
+	index, err := readIndex(path)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
 	log.Println("Index opened successfully")
+
+	// The index was downloaded before the channel was pinned to another release.
+	if tag := index.release(); tag != channel.release() {
+		log.Println("Index is at release", tag+", the channel is pinned to", channel.release())
+		if err := DownloadReleases(path, channel); err == nil {
+			return GetIndex(path, channel)
+		}
+	}
+
+	// Sources registered after the index was downloaded, such as new local sources.
+	if index.Info == nil {
+		index.Info = map[string]string{}
+	}
+	for _, source := range sources {
+		if index.has(source) {
+			continue
+		}
+		data, raw, err := fetchSource(source, channel)
+		index.set(source, data)
+		if err != nil {
+			log.Println(err)
+			index.setHealth(source, StatusFailed, err, "", "")
+		} else {
+			index.setHealth(source, StatusOK, nil, time.Now().Format(time.RFC3339), index.Info["version"])
+		}
+		// The hash covers the content served, these sources included
+		hash := sha256.New()
+		hash.Write([]byte(index.Info["hash"] + "\x00" + source.Name() + "\x00"))
+		hash.Write(raw)
+		index.Info["hash"] = "sha256-" + hex.EncodeToString(hash.Sum(nil))
+	}
+
+	log.Println("Building search index...")
+	log.Println("Search index built:", len(index.inverted().vocab), "tokens")
+
+	log.Println("Linking packages and options...")
+	log.Println("Packages linked to options:", len(index.references().options))
+
+	index.synonyms, err = LoadSynonyms(filepath.Join(filepath.Dir(path), SynonymsFile))
+	if err != nil {
+		log.Println("Invalid synonym dictionary, using the defaults:", err)
+		index.synonyms = DefaultSynonyms
+	}
 	return index
 }
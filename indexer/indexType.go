@@ -1,16 +1,165 @@
 package indexer
 
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// Index holds the packages and options of the registered sources.
+// It is stored as a JSON object with the info and an entry per source, by name.
+// Indexes are built with NewIndex, or read with GetIndex: their search
+// structures are built on first use and shared by their copies.
 type Index struct {
-	Info map[string]string `json:"info"`
+	Info map[string]string
+
+	Packages map[string]Packages // by source, for the sources of KindPackage
+	Options  map[string]Options  // by source, for the sources of KindOption
+
+	derived  *derived // built from the data, see inverted and references
+	synonyms Synonyms // loaded next to the index, see LoadSynonyms
+}
+
+// NewIndex returns an index of the given packages and options, by source name.
+func NewIndex(info map[string]string, packages map[string]Packages, options map[string]Options) Index {
+	index := Index{Info: info, Packages: packages, Options: options, derived: &derived{}}
+	if index.Info == nil {
+		index.Info = map[string]string{}
+	}
+	if index.Packages == nil {
+		index.Packages = map[string]Packages{}
+	}
+	if index.Options == nil {
+		index.Options = map[string]Options{}
+	}
+	return index
+}
 
-	// Options
-	Nixos       Options `json:"nixos"`
-	Homemanager Options `json:"home-manager"`
-	Darwin      Options `json:"darwin"`
+// derived holds the structures built from the data of an index on first use,
+// shared by the copies of the index.
+type derived struct {
+	searchOnce sync.Once
+	search     *searchIndex
+	refsOnce   sync.Once
+	refs       *crossRefs
+}
+
+// inverted returns the search index of the index, built once.
+func (index Index) inverted() *searchIndex {
+	if index.derived == nil {
+		// Not built with NewIndex, nowhere to keep it
+		return buildSearchIndex(index)
+	}
+	index.derived.searchOnce.Do(func() { index.derived.search = buildSearchIndex(index) })
+	return index.derived.search
+}
+
+// references returns the links between packages and options of the index, built once.
+func (index Index) references() *crossRefs {
+	if index.derived == nil {
+		return buildCrossRefs(index)
+	}
+	index.derived.refsOnce.Do(func() { index.derived.refs = buildCrossRefs(index) })
+	return index.derived.refs
+}
+
+func (index Index) MarshalJSON() ([]byte, error) {
+	object := map[string]any{"info": index.Info}
+	for _, source := range sources {
+		switch source.Kind() {
+		case KindPackage:
+			object[source.Name()] = index.Packages[source.Name()]
+		case KindOption:
+			object[source.Name()] = index.Options[source.Name()]
+		}
+	}
+	return json.Marshal(object)
+}
+
+func (index *Index) UnmarshalJSON(data []byte) error {
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*index = NewIndex(nil, nil, nil)
+	if raw, ok := object["info"]; ok {
+		if err := json.Unmarshal(raw, &index.Info); err != nil {
+			return err
+		}
+	}
+	for _, source := range sources {
+		raw, ok := object[source.Name()]
+		if !ok {
+			continue
+		}
+		data := Data{}
+		var err error
+		switch source.Kind() {
+		case KindPackage:
+			err = json.Unmarshal(raw, &data.Packages)
+		case KindOption:
+			err = json.Unmarshal(raw, &data.Options)
+		}
+		if err != nil {
+			return err
+		}
+		index.set(source, data)
+	}
+	return nil
+}
+
+// set sets the packages or options of a source.
+func (index *Index) set(source Source, data Data) {
+	// The derived structures are built again from the new data
+	index.derived = &derived{}
+	if index.Packages == nil {
+		index.Packages = map[string]Packages{}
+	}
+	if index.Options == nil {
+		index.Options = map[string]Options{}
+	}
+	switch source.Kind() {
+	case KindPackage:
+		if data.Packages == nil {
+			data.Packages = Packages{}
+		}
+		index.Packages[source.Name()] = data.Packages
+	case KindOption:
+		if data.Options == nil {
+			data.Options = Options{}
+		}
+		index.Options[source.Name()] = data.Options
+	}
+}
+
+// has reports whether the index holds data for a source, even empty.
+func (index Index) has(source Source) bool {
+	switch source.Kind() {
+	case KindPackage:
+		_, ok := index.Packages[source.Name()]
+		return ok
+	case KindOption:
+		_, ok := index.Options[source.Name()]
+		return ok
+	}
+	return false
+}
+
+// Len returns the number of packages or options of a source.
+func (index Index) Len(source string) int {
+	return len(index.Packages[source]) + len(index.Options[source])
+}
 
-	// Packages
-	Nixpkgs Packages `json:"nixpkgs"`
-	Nur     Packages `json:"nur"`
+// Stats returns the info of the index, with the number of entries of every registered source.
+func (index Index) Stats() map[string]string {
+	stats := map[string]string{}
+	for key, value := range index.Info {
+		stats[key] = value
+	}
+	for _, source := range sources {
+		stats[lengthInfo(source.Name())] = strconv.Itoa(index.Len(source.Name()))
+	}
+	return stats
 }
 
 type Packages map[string]Package
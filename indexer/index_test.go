@@ -0,0 +1,214 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// releaseFiles are the files of a small nix-json release.
+var releaseFiles = map[string]string{
+	"nixpkgs.json":      `{"packages":{"git":{"version":"2.4","meta":{"description":"Distributed version control system","mainProgram":"git"}}}}`,
+	"nixos.json":        `{"services.nginx.enable":{"type":"boolean","description":"Whether to enable nginx"}}`,
+	"home-manager.json": `{}`,
+	"darwin.json":       `{}`,
+	"nur.json":          `{}`,
+}
+
+// writeRelease writes a release to dir with the given version. Files mapped to
+// an empty string in overrides are left out, the others replace the defaults.
+func writeRelease(t *testing.T, dir, version string, overrides map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"version": version}
+	for name, content := range releaseFiles {
+		files[name] = content
+	}
+	for name, content := range overrides {
+		files[name] = content
+	}
+	for name, content := range files {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mustDownload(t *testing.T, path string, channel Channel) Index {
+	t.Helper()
+	if err := DownloadReleases(path, channel); err != nil {
+		t.Fatalf("DownloadReleases() returned error: %v", err)
+	}
+	index, err := readIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func TestDownloadReleases(t *testing.T) {
+	dir := t.TempDir()
+	writeRelease(t, filepath.Join(dir, "release"), "v1", nil)
+	channel := Channel{Name: "test", URL: filepath.Join(dir, "release"), Commit: "main"}
+	path := filepath.Join(dir, "index.json")
+
+	index := mustDownload(t, path, channel)
+	for key, want := range map[string]string{
+		"version":        "v1",
+		"tag":            "latest",
+		"status":         "ok",
+		"nixpkgs-status": "ok",
+		"nixpkgs-length": "1",
+		"nixos-version":  "v1",
+	} {
+		if got := index.Info[key]; got != want {
+			t.Errorf("Info[%q] = %q, want %q", key, got, want)
+		}
+	}
+	if index.Info["hash"] == "" {
+		t.Error("Info has no hash")
+	}
+}
+
+func TestDownloadReleasesFallback(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	channel := Channel{Name: "test", URL: release, Commit: "main"}
+	path := filepath.Join(dir, "index.json")
+	writeRelease(t, release, "v1", nil)
+	previous := mustDownload(t, path, channel)
+
+	// nixpkgs is missing from the next release
+	if err := os.RemoveAll(release); err != nil {
+		t.Fatal(err)
+	}
+	writeRelease(t, release, "v2", map[string]string{"nixpkgs.json": ""})
+	index := mustDownload(t, path, channel)
+
+	for key, want := range map[string]string{
+		"version":         "v2",
+		"status":          "degraded",
+		"nixpkgs-status":  "stale",
+		"nixpkgs-version": "v1",
+		"nixpkgs-updated": previous.Info["nixpkgs-updated"],
+		"nixpkgs-length":  "1",
+		"nixos-status":    "ok",
+		"nixos-version":   "v2",
+	} {
+		if got := index.Info[key]; got != want {
+			t.Errorf("Info[%q] = %q, want %q", key, got, want)
+		}
+	}
+	if index.Info["nixpkgs-error"] == "" {
+		t.Error("Info has no nixpkgs-error")
+	}
+	if _, ok := index.Packages["nixpkgs"]["git"]; !ok {
+		t.Error("the previous nixpkgs packages were not kept")
+	}
+	if index.Info["hash"] == previous.Info["hash"] {
+		t.Error("the hash didn't change with the content")
+	}
+
+	// Once back, the source is ok again
+	writeRelease(t, release, "v3", nil)
+	index = mustDownload(t, path, channel)
+	if index.Info["status"] != "ok" || index.Info["nixpkgs-version"] != "v3" {
+		t.Errorf("Info = %v, want nixpkgs ok at v3", index.Info)
+	}
+	if _, ok := index.Info["nixpkgs-error"]; ok {
+		t.Error("the error of nixpkgs was kept")
+	}
+}
+
+func TestDownloadReleasesFailed(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	writeRelease(t, release, "v1", map[string]string{"nixpkgs.json": "not json"})
+	channel := Channel{Name: "test", URL: release, Commit: "main"}
+
+	// Without previous data, the source is empty
+	index := mustDownload(t, filepath.Join(dir, "index.json"), channel)
+	if index.Info["nixpkgs-status"] != "failed" || index.Info["status"] != "degraded" {
+		t.Errorf("Info = %v, want nixpkgs failed", index.Info)
+	}
+	if index.Len("nixpkgs") != 0 || index.Len("nixos") != 1 {
+		t.Errorf("Len = %d nixpkgs, %d nixos, want 0 and 1", index.Len("nixpkgs"), index.Len("nixos"))
+	}
+}
+
+// assertUntouched checks that the index at path is still the given one.
+func assertUntouched(t *testing.T, path string, want Index) {
+	t.Helper()
+	index, err := readIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Info["hash"] != want.Info["hash"] || index.Info["version"] != want.Info["version"] {
+		t.Errorf("the index was replaced: %v, want %v", index.Info, want.Info)
+	}
+}
+
+func TestDownloadReleasesKeepsIndex(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	channel := Channel{Name: "test", URL: release, Commit: "main"}
+	path := filepath.Join(dir, "index.json")
+	writeRelease(t, release, "v1", nil)
+	previous := mustDownload(t, path, channel)
+
+	t.Run("every source fails", func(t *testing.T) {
+		if err := os.RemoveAll(release); err != nil {
+			t.Fatal(err)
+		}
+		writeRelease(t, release, "v2", map[string]string{
+			"nixpkgs.json": "", "nixos.json": "", "home-manager.json": "", "darwin.json": "", "nur.json": "",
+		})
+		if err := DownloadReleases(path, channel); err == nil {
+			t.Error("DownloadReleases() returned no error")
+		}
+		assertUntouched(t, path, previous)
+	})
+
+	t.Run("missing release", func(t *testing.T) {
+		if err := os.RemoveAll(release); err != nil {
+			t.Fatal(err)
+		}
+		if err := DownloadReleases(path, channel); err == nil {
+			t.Error("DownloadReleases() returned no error")
+		}
+		assertUntouched(t, path, previous)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		writeRelease(t, release, "v2", map[string]string{"nixpkgs.json": "not json"})
+		if err := DownloadReleasesStrict(path, channel); err == nil {
+			t.Error("DownloadReleasesStrict() returned no error")
+		}
+		assertUntouched(t, path, previous)
+	})
+}
+
+func TestDownloadReleasesPinned(t *testing.T) {
+	dir := t.TempDir()
+	channel := Channel{Name: "test", URL: filepath.Join(dir, "{tag}"), Commit: "main", Tag: "v1"}
+	path := filepath.Join(dir, "index.json")
+	writeRelease(t, filepath.Join(dir, "v1"), "v1", nil)
+	writeRelease(t, filepath.Join(dir, "v2"), "v2", map[string]string{"nixpkgs.json": ""})
+
+	previous := mustDownload(t, path, channel)
+	if previous.Info["tag"] != "v1" {
+		t.Errorf("Info[tag] = %q, want v1", previous.Info["tag"])
+	}
+
+	// nixpkgs of v1 can't stand in for the one of v2
+	channel.Tag = "v2"
+	if err := DownloadReleases(path, channel); err == nil {
+		t.Error("DownloadReleases() returned no error")
+	}
+	assertUntouched(t, path, previous)
+}
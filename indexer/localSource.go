@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// sourceName matches the valid names of sources, used in routes and qualifiers.
+var sourceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// storePath matches the store path of the sources of a module, e.g. "/nix/store/<hash>-source/".
+var storePath = regexp.MustCompile(`^/nix/store/[0-9a-z]{32}-[^/]*/`)
+
+// localOptionsSource is a source of options read from local options.json files,
+// in the format of nixosOptionsDoc, e.g. the options of custom modules or of a flake.
+type localOptionsSource struct {
+	name        string
+	path        string // a file, or a directory of files
+	declaration string // URL template of the declarations, see NewLocalOptionsSource
+}
+
+// NewLocalOptionsSource returns a source of options read from a local options.json
+// file, or from all the .json files of a directory.
+//
+// Declarations are linked with the declaration template, where "{file}" is
+// replaced by the path of the declaration, without its store path, e.g.
+// "https://git.example.org/modules/blob/main/{file}". An empty template keeps
+// the declarations as is.
+func NewLocalOptionsSource(name, path, declaration string) (Source, error) {
+	if !sourceName.MatchString(name) {
+		return nil, fmt.Errorf("invalid source name %q, expected lowercase letters, digits, - and _", name)
+	}
+	if _, ok := SourceByName(name); ok {
+		return nil, fmt.Errorf("source %q is already registered", name)
+	}
+	if !DoesFileExist(path) {
+		return nil, fmt.Errorf("options of source %q not found: %s", name, path)
+	}
+	return localOptionsSource{name: name, path: path, declaration: declaration}, nil
+}
+
+// ParseLocalOptionsSources parses a comma separated list of local option sources,
+// as "name=path" or "name=path@template", e.g. "mymodules=/etc/modules/options.json@https://git.example.org/modules/blob/main/{file}".
+func ParseLocalOptionsSources(s string) ([]Source, error) {
+	res := []Source{}
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid option source %q, expected name=path or name=path@template", entry)
+		}
+		path, declaration, _ := strings.Cut(location, "@")
+		source, err := NewLocalOptionsSource(strings.TrimSpace(name), strings.TrimSpace(path), strings.TrimSpace(declaration))
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range res {
+			if other.Name() == source.Name() {
+				return nil, fmt.Errorf("duplicate option source %q", source.Name())
+			}
+		}
+		res = append(res, source)
+	}
+	return res, nil
+}
+
+func (s localOptionsSource) Name() string     { return s.name }
+func (s localOptionsSource) Kind() SourceKind { return KindOption }
+func (s localOptionsSource) Prefix() string   { return s.name + "/option/" }
+
+// Fetch reads the options file, or merges the options of the .json files of the directory.
+// The options are the same for every channel.
+func (s localOptionsSource) Fetch(channel Channel) ([]byte, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.ReadFile(s.path)
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	options := map[string]json.RawMessage{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &options); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return json.Marshal(options)
+}
+
+func (s localOptionsSource) Normalize(raw []byte, channel Channel) (Data, error) {
+	return normalizeOptionsDoc(raw, s.name, func(d string) string {
+		if s.declaration == "" {
+			return d
+		}
+		return strings.ReplaceAll(s.declaration, "{file}", storePath.ReplaceAllString(d, ""))
+	})
+}
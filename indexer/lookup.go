@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// MaxLookupKeys is the maximum number of keys looked up at once.
+const MaxLookupKeys = 1000
+
+// LookupKey is a key to look up in a source, or in all of them if Source is empty.
+// It can be decoded from a {"source", "key"} object or from a bare key string.
+type LookupKey struct {
+	Source string `json:"source,omitempty"`
+	Key    string `json:"key"`
+}
+
+func (k *LookupKey) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &k.Key); err == nil {
+		k.Source = ""
+		return nil
+	}
+	type lookupKey LookupKey
+	return json.Unmarshal(data, (*lookupKey)(k))
+}
+
+// LookupRecord is a package or an option found by Lookup.
+type LookupRecord struct {
+	Type   string `json:"type"` // "package" or "option"
+	Source string `json:"source"`
+	Key    string `json:"key"`
+	Record any    `json:"record"` // Package or Option
+}
+
+// LookupResult holds the records found by Lookup, and the keys matching none.
+type LookupResult struct {
+	Found   []LookupRecord `json:"found"`
+	Missing []LookupKey    `json:"missing"`
+}
+
+// Lookup returns the packages and options of a list of keys.
+// A key without a source is looked up in every source, and may be found in several of them.
+func (index Index) Lookup(keys []LookupKey) (LookupResult, error) {
+	if len(keys) > MaxLookupKeys {
+		return LookupResult{}, fmt.Errorf("too many keys, at most %d are allowed", MaxLookupKeys)
+	}
+	for _, k := range keys {
+		if k.Source != "" && !slices.Contains(SourceNames(), k.Source) {
+			return LookupResult{}, fmt.Errorf("%w %q", ErrUnknownSource, k.Source)
+		}
+	}
+
+	res := LookupResult{Found: []LookupRecord{}, Missing: []LookupKey{}}
+	for _, k := range keys {
+		found := false
+		for _, section := range SourceNames() {
+			if k.Source != "" && k.Source != section {
+				continue
+			}
+			d := &document{section: section, key: k.Key}
+			if pkg, ok := index.packageOf(d); ok {
+				res.Found = append(res.Found, LookupRecord{Type: "package", Source: section, Key: k.Key, Record: pkg})
+				found = true
+			} else if opt, ok := index.optionOf(d); ok {
+				res.Found = append(res.Found, LookupRecord{Type: "option", Source: section, Key: k.Key, Record: opt})
+				found = true
+			}
+		}
+		if !found {
+			res.Missing = append(res.Missing, k)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrUnknownSource = errors.New("unknown source")
+	ErrNotFound      = errors.New("not found")
+)
+
+// optionTree is a node of the tree of the dotted paths of the options of a source.
+type optionTree struct {
+	children map[string]*optionTree
+	option   bool // the path of the node is an option
+	count    int  // number of options in the subtree, including the node
+}
+
+// insert adds an option to the tree.
+func (t *optionTree) insert(key string) {
+	node := t
+	node.count++
+	for _, segment := range splitOptionPath(key) {
+		if node.children == nil {
+			node.children = map[string]*optionTree{}
+		}
+		child, ok := node.children[segment]
+		if !ok {
+			child = &optionTree{}
+			node.children[segment] = child
+		}
+		node = child
+		node.count++
+	}
+	node.option = true
+}
+
+// splitOptionPath splits an option path on dots, except on quoted dots:
+// services.foo."bar.baz" has three segments.
+func splitOptionPath(path string) []string {
+	segments := []string{}
+	if path == "" {
+		return segments
+	}
+	quoted := false
+	start := 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '"':
+			quoted = !quoted
+		case '.':
+			if !quoted {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(segments, path[start:])
+}
+
+// OptionTreeNode is a node of the option tree of a source.
+type OptionTreeNode struct {
+	Source   string            `json:"source"`
+	Path     string            `json:"path"`
+	Count    int               `json:"count"`            // options under this node, including itself
+	Option   *Option           `json:"option,omitempty"` // if the node is an option
+	Children []OptionTreeChild `json:"children"`
+}
+
+// OptionTreeChild is a child of a node of the option tree.
+type OptionTreeChild struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Count  int    `json:"count"`  // options under this node, including itself
+	Option bool   `json:"option"` // the node is an option
+	Leaf   bool   `json:"leaf"`   // the node is an option without children
+}
+
+// OptionTree returns the node of the option tree of a source (nixos, home-manager
+// or darwin) at a dotted path, with its children. An empty path is the root.
+func (index Index) OptionTree(source, path string) (OptionTreeNode, error) {
+	s := index.inverted()
+
+	tree, ok := s.trees[source]
+	if !ok {
+		return OptionTreeNode{}, ErrUnknownSource
+	}
+	node := tree
+	for _, segment := range splitOptionPath(path) {
+		node, ok = node.children[segment]
+		if !ok {
+			return OptionTreeNode{}, ErrNotFound
+		}
+	}
+
+	res := OptionTreeNode{
+		Source:   source,
+		Path:     path,
+		Count:    node.count,
+		Children: []OptionTreeChild{},
+	}
+	if node.option {
+		d := document{section: source, key: path}
+		if opt, ok := index.optionOf(&d); ok {
+			res.Option = &opt
+		}
+	}
+	for name, child := range node.children {
+		childPath := name
+		if path != "" {
+			childPath = path + "." + name
+		}
+		res.Children = append(res.Children, OptionTreeChild{
+			Name:   name,
+			Path:   childPath,
+			Count:  child.count,
+			Option: child.option,
+			Leaf:   child.option && len(child.children) == 0,
+		})
+	}
+	sort.Slice(res.Children, func(i, j int) bool {
+		return res.Children[i].Name < res.Children[j].Name
+	})
+	return res, nil
+}
@@ -0,0 +1,101 @@
+package indexer
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// variantTokens are tokens of an attribute name marking a variant of a package
+// rather than the package itself (e.g. firefox-unwrapped, ffmpeg-full, gitFull)
+// when they follow the base name.
+var variantTokens = []string{
+	"unwrapped", "wrapped", "wrapper", "bin", "full", "minimal", "headless",
+	"git", "nightly", "beta", "unstable", "devel", "static", "debug",
+}
+
+// isVariant reports whether a key looks like a variant of a package: a variant
+// token follows the base name of its attribute, as in "neovim-unwrapped" or
+// "gitFull", while "git" itself is not a variant.
+func isVariant(key string) bool {
+	name := key[strings.LastIndex(key, ".")+1:]
+	lower := strings.ToLower(name)
+	if !slices.ContainsFunc(variantTokens, func(t string) bool { return strings.Contains(lower, t) }) {
+		return false
+	}
+	tokens := nameTokens(name)
+	for _, t := range tokens[min(1, len(tokens)):] {
+		if slices.Contains(variantTokens, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameTokens splits an attribute name into lowercased tokens, on non
+// alphanumeric characters and on camelCase boundaries, e.g. "gitFull" into
+// "git" and "full".
+func nameTokens(name string) []string {
+	tokens := []string{}
+	current := []rune{}
+	previous := rune(0)
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(current) > 0 {
+				tokens = append(tokens, string(current))
+				current = current[:0]
+			}
+		case unicode.IsUpper(r) && unicode.IsLower(previous) && len(current) > 0:
+			tokens = append(tokens, string(current))
+			current = []rune{unicode.ToLower(r)}
+		default:
+			current = append(current, unicode.ToLower(r))
+		}
+		previous = r
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+	return tokens
+}
+
+// Providers returns the packages whose main program is the given command,
+// e.g. "rg" is provided by ripgrep.
+// Canonical packages come first: nixpkgs before NUR, then packages that are not
+// variants or wrappers, then packages named after the program, then top-level
+// attributes before nested ones.
+func (index Index) Providers(program string) []PackageOrOption {
+	s := index.inverted()
+
+	items := []PackageOrOption{}
+	for _, id := range s.programs[strings.ToLower(program)] {
+		items = append(items, index.item(&s.docs[id], 0))
+	}
+
+	rank := func(item PackageOrOption) []int {
+		r := []int{0, 0, 0, 0}
+		if item.Source != "nixpkgs" {
+			r[0] = 1
+		}
+		if isVariant(item.Key) {
+			r[1] = 1
+		}
+		if name := item.Key[strings.LastIndex(item.Key, ".")+1:]; !strings.EqualFold(name, program) {
+			r[2] = 1
+		}
+		r[3] = strings.Count(item.Key, ".")
+		return r
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if c := slices.Compare(rank(items[i]), rank(items[j])); c != 0 {
+			return c < 0
+		}
+		if len(items[i].Key) != len(items[j].Key) {
+			return len(items[i].Key) < len(items[j].Key)
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
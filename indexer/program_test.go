@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsVariant(t *testing.T) {
+	tests := map[string]bool{
+		"git":                      false,
+		"bin":                      false,
+		"full":                     false,
+		"ripgrep":                  false,
+		"python3Packages.git":      false,
+		"gitFull":                  true,
+		"gitMinimal":               true,
+		"git-bin":                  true,
+		"neovim-unwrapped":         true,
+		"firefox-unwrapped-addons": true,
+		"ffmpeg_7-full":            true,
+		"hello-git":                true,
+		"python3Packages.foo-git":  true,
+		"gitAndTools.git-extras":   false,
+		"fullscreen":               false,
+		"binutils":                 false,
+	}
+	for key, want := range tests {
+		if got := isVariant(key); got != want {
+			t.Errorf("isVariant(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestProviders(t *testing.T) {
+	index := NewIndex(nil, map[string]Packages{
+		"nixpkgs": {
+			"gitFull":             {Source: "nixpkgs", MainProgram: "git"},
+			"git":                 {Source: "nixpkgs", MainProgram: "git"},
+			"gitMinimal":          {Source: "nixpkgs", MainProgram: "git"},
+			"pkgsStatic.git":      {Source: "nixpkgs", MainProgram: "git"},
+			"git-wrapped":         {Source: "nixpkgs", MainProgram: "git"},
+			"ripgrep":             {Source: "nixpkgs", MainProgram: "rg"},
+			"python3Packages.rg2": {Source: "nixpkgs", MainProgram: "RG"},
+		},
+		"nur": {
+			"repos.x.git": {Source: "nur", MainProgram: "git"},
+		},
+	}, nil)
+
+	tests := map[string][]string{
+		"git": {"git", "pkgsStatic.git", "gitFull", "gitMinimal", "git-wrapped", "repos.x.git"},
+		"rg":  {"ripgrep", "python3Packages.rg2"},
+		"Rg":  {"ripgrep", "python3Packages.rg2"},
+		"foo": {},
+	}
+	for program, want := range tests {
+		got := []string{}
+		for _, item := range index.Providers(program) {
+			got = append(got, item.Key)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Providers(%q) = %v, want %v", program, got, want)
+		}
+	}
+}
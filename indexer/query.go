@@ -0,0 +1,467 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Qualifiers supported in queries, as in "maintainer:anotherhadi".
+const (
+	QualifierMaintainer = "maintainer"
+	QualifierLicense    = "license"  // SPDX identifier
+	QualifierPlatform   = "platform" // e.g. linux, x86_64-darwin
+	QualifierFree       = "free"
+	QualifierUnfree     = "unfree"
+	QualifierBroken     = "broken"
+	QualifierVulnerable = "vulnerable"
+	QualifierSource     = "source" // nixpkgs, nur, nixos, home-manager, darwin
+	QualifierType       = "type"   // option type, e.g. boolean
+	QualifierProgram    = "program"
+	QualifierKind       = "kind" // package or option
+)
+
+var qualifiers = []string{
+	QualifierMaintainer,
+	QualifierLicense,
+	QualifierPlatform,
+	QualifierFree,
+	QualifierUnfree,
+	QualifierBroken,
+	QualifierVulnerable,
+	QualifierSource,
+	QualifierType,
+	QualifierProgram,
+	QualifierKind,
+}
+
+// boolQualifiers are the qualifiers whose value is a boolean.
+// Their value can be omitted: "broken:" is the same as "broken:true".
+var boolQualifiers = []string{
+	QualifierFree,
+	QualifierUnfree,
+	QualifierBroken,
+	QualifierVulnerable,
+}
+
+// Operator is the kind of a node of a query.
+type Operator int
+
+const (
+	OperatorClause Operator = iota // a single clause
+	OperatorAnd                    // all children must match
+	OperatorOr                     // at least one child must match
+)
+
+// Query is a parsed search query.
+type Query struct {
+	Root Node
+}
+
+// Node is a node of a parsed query: a clause, or a group of nodes
+// combined with AND or OR.
+type Node struct {
+	Operator Operator
+	Negated  bool   // prefixed with "-"
+	Clause   Clause // for OperatorClause
+	Children []Node // for OperatorAnd and OperatorOr
+}
+
+// Clause is a search term or a qualifier of a query.
+type Clause struct {
+	Qualifier string // empty for a search term
+	Value     string
+	Phrase    bool // quoted, the words must appear next to each other
+	Exact     bool // only matches whole tokens, without prefixes, substrings nor typos
+}
+
+// IsEmpty reports whether the query has no clauses.
+func (q Query) IsEmpty() bool {
+	return len(q.Root.Children) == 0
+}
+
+// Terms returns the search terms of the query that are not negated.
+func (q Query) Terms() []Clause {
+	terms := []Clause{}
+	var walk func(n Node)
+	walk = func(n Node) {
+		if n.Negated {
+			return
+		}
+		if n.Operator == OperatorClause {
+			if n.Clause.Qualifier == "" {
+				terms = append(terms, n.Clause)
+			}
+			return
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(q.Root)
+	return terms
+}
+
+// ParseQuery parses a search query.
+//
+// A query is a list of search terms and qualifiers separated by spaces, all of
+// which must match. Terms can be quoted to search for a phrase, alternatives are
+// separated by OR, parentheses group terms, and terms, qualifiers or groups can
+// be negated with a leading "-":
+//
+//	"pdf viewer" license:mit -broken: (platform:darwin OR platform:linux)
+//
+// For compatibility, a leading "package" or "option" word restricts the kind of
+// results, "!nixos" excludes a source, and "?maintainer=name", "?broken" and
+// "?vulnerable" are the same as the corresponding qualifiers.
+func ParseQuery(query string) (Query, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return Query{}, err
+	}
+	if len(tokens) > 0 && tokens[0].kind == tokenWord &&
+		(tokens[0].text == "package" || tokens[0].text == "option") {
+		tokens[0].kind = tokenClause
+		tokens[0].clause = Clause{Qualifier: QualifierKind, Value: tokens[0].text}
+	}
+
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return Query{}, err
+	}
+	if p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		return Query{}, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	if root.Operator != OperatorAnd {
+		root = Node{Operator: OperatorAnd, Children: []Node{root}}
+	}
+	return Query{Root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // unquoted word, not parsed yet
+	tokenClause                  // quoted phrase or qualifier
+	tokenOr
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind    tokenKind
+	text    string
+	pos     int
+	negated bool
+	clause  Clause // for tokenClause
+}
+
+// lexQuery splits a query into tokens: words, quoted phrases, OR and parentheses.
+// A quoted phrase can be preceded by "-" or a qualifier: -"foo bar", maintainer:"Jane Doe".
+// A parenthesis can be preceded by "-" to negate the group.
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+			continue
+		case runes[i] == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "(", pos: i})
+			i++
+			continue
+		case runes[i] == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")", pos: i})
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`"()`, runes[i]) {
+			i++
+		}
+		prefix := string(runes[start:i])
+		next := rune(0)
+		if i < len(runes) {
+			next = runes[i]
+		}
+
+		switch {
+		case next == '(' && prefix == "-":
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "-(", pos: start, negated: true})
+			i++
+		case next == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated quoted phrase at position %d", i)
+			}
+			t := queryToken{
+				kind:   tokenClause,
+				text:   string(runes[start : end+1]),
+				pos:    start,
+				clause: Clause{Value: string(runes[i+1 : end]), Phrase: true},
+			}
+			switch {
+			case prefix == "":
+			case prefix == "-":
+				t.negated = true
+			case strings.HasSuffix(prefix, ":"):
+				name := strings.TrimPrefix(strings.TrimSuffix(prefix, ":"), "-")
+				if !slices.Contains(qualifiers, strings.ToLower(name)) {
+					return nil, fmt.Errorf("unknown qualifier %q at position %d", name, start)
+				}
+				t.clause.Qualifier = strings.ToLower(name)
+				t.clause.Phrase = false
+				t.negated = strings.HasPrefix(prefix, "-")
+			default:
+				return nil, fmt.Errorf("unexpected quote after %q at position %d", prefix, start)
+			}
+			tokens = append(tokens, t)
+			i = end + 1
+		case prefix == "OR":
+			tokens = append(tokens, queryToken{kind: tokenOr, text: prefix, pos: start})
+		default:
+			tokens = append(tokens, queryToken{kind: tokenWord, text: prefix, pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over the tokens of a query:
+//
+//	or   = and { "OR" and }
+//	and  = unary { unary }
+//	unary = [ "-" ] ( "(" or ")" | clause )
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return Node{}, err
+	}
+	alternatives := []Node{first}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOr {
+		or := p.tokens[p.pos]
+		if len(first.Children) == 0 {
+			return Node{}, fmt.Errorf("missing term before OR at position %d", or.pos)
+		}
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return Node{}, err
+		}
+		if len(next.Children) == 0 {
+			return Node{}, fmt.Errorf("missing term after OR at position %d", or.pos)
+		}
+		alternatives = append(alternatives, next)
+	}
+	if len(alternatives) == 1 {
+		return first, nil
+	}
+	for i, alternative := range alternatives {
+		if len(alternative.Children) == 1 {
+			alternatives[i] = alternative.Children[0]
+		}
+	}
+	return Node{Operator: OperatorOr, Children: alternatives}, nil
+}
+
+func (p *queryParser) parseAnd() (Node, error) {
+	n := Node{Operator: OperatorAnd, Children: []Node{}}
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		if t.kind == tokenOr || t.kind == tokenClose {
+			break
+		}
+		child, ok, err := p.parseUnary()
+		if err != nil {
+			return Node{}, err
+		}
+		if ok {
+			n.Children = append(n.Children, child)
+		}
+	}
+	return n, nil
+}
+
+// parseUnary parses a clause or a group. It returns false for words without
+// any searchable content, such as a lone "-".
+func (p *queryParser) parseUnary() (Node, bool, error) {
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case tokenOpen:
+		group, err := p.parseOr()
+		if err != nil {
+			return Node{}, false, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenClose {
+			return Node{}, false, fmt.Errorf("missing closing parenthesis for %q at position %d", t.text, t.pos)
+		}
+		p.pos++
+		if group.Operator == OperatorAnd && len(group.Children) == 0 {
+			return Node{}, false, fmt.Errorf("empty group at position %d", t.pos)
+		}
+		if group.Operator == OperatorAnd && len(group.Children) == 1 {
+			group = group.Children[0]
+		}
+		group.Negated = group.Negated != t.negated
+		return group, true, nil
+	case tokenWord:
+		t.clause, t.negated = parseClause(t.text)
+	}
+
+	if t.clause.Qualifier != "" {
+		if err := validateQualifier(t.clause); err != nil {
+			return Node{}, false, fmt.Errorf("%w at position %d", err, t.pos)
+		}
+	} else if len(tokenize(t.clause.Value)) == 0 {
+		return Node{}, false, nil
+	}
+	return Node{Operator: OperatorClause, Negated: t.negated, Clause: t.clause}, true, nil
+}
+
+// parseClause parses an unquoted word of a query, and tells whether it is negated.
+func parseClause(word string) (Clause, bool) {
+	// Legacy syntax
+	if section, ok := strings.CutPrefix(word, "!"); ok && slices.Contains(SourceNames(), section) {
+		return Clause{Qualifier: QualifierSource, Value: section}, true
+	}
+	if name, ok := strings.CutPrefix(word, "?maintainer="); ok {
+		return Clause{Qualifier: QualifierMaintainer, Value: name}, false
+	}
+	if strings.HasPrefix(word, "?broken") {
+		return Clause{Qualifier: QualifierBroken, Value: "true"}, false
+	}
+	if strings.HasPrefix(word, "?vulnerable") {
+		return Clause{Qualifier: QualifierVulnerable, Value: "true"}, false
+	}
+
+	c := Clause{Value: word}
+	negated := false
+	if rest, ok := strings.CutPrefix(word, "-"); ok {
+		negated = true
+		c.Value = rest
+	}
+	if name, value, ok := strings.Cut(c.Value, ":"); ok &&
+		slices.Contains(qualifiers, strings.ToLower(name)) {
+		c.Qualifier = strings.ToLower(name)
+		c.Value = value
+	}
+	return c, negated
+}
+
+// validateQualifier checks the value of a qualifier clause.
+func validateQualifier(c Clause) error {
+	if slices.Contains(boolQualifiers, c.Qualifier) {
+		if _, err := parseBool(c.Value); err != nil {
+			return fmt.Errorf("invalid value for qualifier %q: %w", c.Qualifier, err)
+		}
+		return nil
+	}
+	if c.Value == "" {
+		return fmt.Errorf("missing value for qualifier %q", c.Qualifier)
+	}
+	switch c.Qualifier {
+	case QualifierSource:
+		if !slices.Contains(SourceNames(), strings.ToLower(c.Value)) {
+			return fmt.Errorf(
+				"unknown source %q, expected one of %s",
+				c.Value,
+				strings.Join(SourceNames(), ", "),
+			)
+		}
+	case QualifierKind:
+		if c.Value != "package" && c.Value != "option" {
+			return fmt.Errorf("unknown kind %q, expected package or option", c.Value)
+		}
+	}
+	return nil
+}
+
+// parseBool parses the value of a boolean qualifier. An empty value is true.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "1":
+		return true, nil
+	case "false", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", value)
+}
+
+// filter returns the function telling whether a document matches a qualifier clause.
+// The clause must have been validated by ParseQuery.
+func (index Index) filter(c Clause) func(d *document) bool {
+	value := strings.ToLower(c.Value)
+	b, _ := parseBool(c.Value)
+
+	var keepPackage func(pkg Package) bool
+	var keepOption func(opt Option) bool
+	switch c.Qualifier {
+	case QualifierSource:
+		return func(d *document) bool { return d.section == value }
+	case QualifierKind:
+		return func(d *document) bool { return d.kind == SourceKind(value) }
+	case QualifierMaintainer:
+		keepPackage = func(pkg Package) bool {
+			for _, m := range pkg.Maintainers {
+				if strings.EqualFold(m.GitHub, value) || strings.EqualFold(m.Name, value) {
+					return true
+				}
+			}
+			return false
+		}
+	case QualifierLicense:
+		keepPackage = func(pkg Package) bool {
+			for _, l := range pkg.Licenses {
+				if strings.EqualFold(l.SpdxID, value) {
+					return true
+				}
+			}
+			return false
+		}
+	case QualifierPlatform:
+		keepPackage = func(pkg Package) bool {
+			return slices.Contains(pkg.PlatformsSimplify, value) ||
+				slices.Contains(pkg.Platforms, value)
+		}
+	case QualifierFree:
+		keepPackage = func(pkg Package) bool { return pkg.Unfree != b }
+	case QualifierUnfree:
+		keepPackage = func(pkg Package) bool { return pkg.Unfree == b }
+	case QualifierBroken:
+		keepPackage = func(pkg Package) bool { return pkg.Broken == b }
+	case QualifierVulnerable:
+		keepPackage = func(pkg Package) bool { return pkg.Vulnerable == b }
+	case QualifierProgram:
+		keepPackage = func(pkg Package) bool { return strings.EqualFold(pkg.MainProgram, value) }
+	case QualifierType:
+		keepOption = func(opt Option) bool {
+			return strings.Contains(strings.ToLower(opt.Type), value)
+		}
+	}
+
+	return func(d *document) bool {
+		if keepPackage != nil {
+			pkg, ok := index.packageOf(d)
+			return ok && keepPackage(pkg)
+		}
+		if keepOption != nil {
+			opt, ok := index.optionOf(d)
+			return ok && keepOption(opt)
+		}
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func term(value string) Node {
+	return Node{Operator: OperatorClause, Clause: Clause{Value: value}}
+}
+
+func phrase(value string) Node {
+	return Node{Operator: OperatorClause, Clause: Clause{Value: value, Phrase: true}}
+}
+
+func qualifier(name, value string) Node {
+	return Node{Operator: OperatorClause, Clause: Clause{Qualifier: name, Value: value}}
+}
+
+func not(n Node) Node {
+	n.Negated = true
+	return n
+}
+
+func and(children ...Node) Node {
+	return Node{Operator: OperatorAnd, Children: append([]Node{}, children...)}
+}
+
+func or(children ...Node) Node {
+	return Node{Operator: OperatorOr, Children: children}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Node
+	}{
+		{"", and()},
+		{"   ", and()},
+		{"firefox", and(term("firefox"))},
+		{"pdf viewer", and(term("pdf"), term("viewer"))},
+		{"^services.nginx nginx$", and(term("^services.nginx"), term("nginx$"))},
+		{`"pdf viewer"`, and(phrase("pdf viewer"))},
+		{`-"pdf viewer"`, and(not(phrase("pdf viewer")))},
+		{"-unwrapped", and(not(term("unwrapped")))},
+		{"- firefox", and(term("firefox"))},
+		{"maintainer:anotherhadi", and(qualifier(QualifierMaintainer, "anotherhadi"))},
+		{"License:MIT", and(qualifier(QualifierLicense, "MIT"))},
+		{`maintainer:"Jane Doe"`, and(qualifier(QualifierMaintainer, "Jane Doe"))},
+		{`-maintainer:"Jane Doe"`, and(not(qualifier(QualifierMaintainer, "Jane Doe")))},
+		{"broken:", and(qualifier(QualifierBroken, ""))},
+		{"-source:nur", and(not(qualifier(QualifierSource, "nur")))},
+		{"foo:bar", and(term("foo:bar"))},
+		{"postgresql OR mysql", and(or(term("postgresql"), term("mysql")))},
+		{
+			"a b OR c",
+			and(or(and(term("a"), term("b")), term("c"))),
+		},
+		{
+			"(postgresql OR mysql) server",
+			and(or(term("postgresql"), term("mysql")), term("server")),
+		},
+		{
+			"-(source:nixpkgs OR source:nixos) fire",
+			and(not(or(qualifier(QualifierSource, "nixpkgs"), qualifier(QualifierSource, "nixos"))), term("fire")),
+		},
+		{"(firefox)", and(term("firefox"))},
+		{"-(firefox)", and(not(term("firefox")))},
+		{"((a OR b))", and(or(term("a"), term("b")))},
+		{"or", and(term("or"))},
+
+		// Legacy syntax
+		{"package firefox", and(qualifier(QualifierKind, "package"), term("firefox"))},
+		{"option nginx", and(qualifier(QualifierKind, "option"), term("nginx"))},
+		{"firefox package", and(term("firefox"), term("package"))},
+		{"python !nixpkgs", and(term("python"), not(qualifier(QualifierSource, "nixpkgs")))},
+		{"!unknown", and(term("!unknown"))},
+		{"?maintainer=hadi", and(qualifier(QualifierMaintainer, "hadi"))},
+		{"?broken", and(qualifier(QualifierBroken, "true"))},
+		{"?vulnerable", and(qualifier(QualifierVulnerable, "true"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) returned error: %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(q.Root, tt.want) {
+				t.Errorf("ParseQuery(%q) =\n%+v\nwant\n%+v", tt.query, q.Root, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`"pdf viewer`, "unterminated quoted phrase at position 0"},
+		{`firefox "pdf`, "unterminated quoted phrase at position 8"},
+		{`foo:"bar"`, `unknown qualifier "foo" at position 0`},
+		{`abc"def"`, `unexpected quote after "abc" at position 0`},
+		{"(firefox", `missing closing parenthesis for "(" at position 0`},
+		{"a -(b", `missing closing parenthesis for "-(" at position 2`},
+		{"firefox)", `unexpected ")" at position 7`},
+		{"()", "empty group at position 0"},
+		{"a () b", "empty group at position 2"},
+		{"OR firefox", "missing term before OR at position 0"},
+		{"firefox OR", "missing term after OR at position 8"},
+		{"a OR OR b", "missing term after OR at position 2"},
+		{"broken:maybe", `invalid value for qualifier "broken": "maybe" is not a boolean at position 0`},
+		{"x license:", `missing value for qualifier "license" at position 2`},
+		{"source:foo", `unknown source "foo", expected one of nixpkgs, nixos, home-manager, darwin, nur at position 0`},
+		{"kind:module", `unknown kind "module", expected package or option at position 0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query)
+			if err == nil {
+				t.Fatalf("ParseQuery(%q) returned no error, want %q", tt.query, tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("ParseQuery(%q) error = %q, want %q", tt.query, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	q, err := ParseQuery(`firefox -unwrapped "web browser" license:MIT (a OR -b) -(c d)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Clause{
+		{Value: "firefox"},
+		{Value: "web browser", Phrase: true},
+		{Value: "a"},
+	}
+	if got := q.Terms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package indexer
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+// Limits of the patterns accepted by SearchRegex.
+const (
+	maxRegexLength = 256 // characters
+	maxRegexSize   = 500 // instructions of the compiled program
+)
+
+// CompileSearchRegex compiles a RE2 pattern for SearchRegex, case insensitively
+// unless the pattern starts with "(?-i)".
+// Patterns longer than maxRegexLength characters, or compiling to more than
+// maxRegexSize instructions (e.g. "(a{30}){30}"), are rejected.
+func CompileSearchRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("pattern is longer than %d characters", maxRegexLength)
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxRegexSize {
+		return nil, fmt.Errorf("pattern is too complex")
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// SearchRegex returns the packages and options whose key matches a RE2 pattern.
+// The pattern is not anchored and is case insensitive, use "^", "$" and "(?-i)" as needed.
+// Results are sorted by the length of the key after stripping the prefix.
+func (index Index) SearchRegex(pattern string) ([]PackageOrOption, error) {
+	re, err := CompileSearchRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	s := index.inverted()
+
+	items := []PackageOrOption{}
+	for _, id := range s.sorted {
+		if re.MatchString(s.docs[id].key) {
+			items = append(items, index.item(&s.docs[id], 0))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return lessByKeyLength(items[i], items[j])
+	})
+	return items, nil
+}
@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSearchRegex(t *testing.T) {
+	index := NewIndex(nil, map[string]Packages{
+		"nixpkgs": {
+			"libsForQt5.qtbase":      {Source: "nixpkgs"},
+			"libsforqt5-compat":      {Source: "nixpkgs"},
+			"python3Packages.django": {Source: "nixpkgs"},
+		},
+	}, nil)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"^libsforqt5", []string{"libsForQt5.qtbase", "libsforqt5-compat"}},
+		{"(?-i)^libsForQt5", []string{"libsForQt5.qtbase"}},
+		{"(?-i)^libsforqt5", []string{"libsforqt5-compat"}},
+		{"DJANGO$", []string{"python3Packages.django"}},
+		{"(?i)Django", []string{"python3Packages.django"}},
+		{"^qtbase", []string{}},
+	}
+	for _, tt := range tests {
+		results, err := index.SearchRegex(tt.pattern)
+		if err != nil {
+			t.Errorf("SearchRegex(%q) returned error: %v", tt.pattern, err)
+			continue
+		}
+		if got := sortedKeys(results); !slices.Equal(got, tt.want) {
+			t.Errorf("SearchRegex(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"", "(", "(a{30}){30}", string(make([]byte, maxRegexLength+1))} {
+		if _, err := index.SearchRegex(pattern); err == nil {
+			t.Errorf("SearchRegex(%q) returned no error", pattern)
+		}
+	}
+}
@@ -1,113 +1,138 @@
 package indexer
 
 import (
-	"regexp"
 	"slices"
-	"sort"
 	"strings"
 )
 
 // PackageOrOption represents a package or option result.
 type PackageOrOption struct {
-	Type        string // "package" or "option"
-	Source      string
-	Key         string
-	Description string
-
-	Broken     bool
-	Insecure   bool
-	Vulnerable bool
+	Type        string `json:"type"`    // "package" or "option"
+	Section     string `json:"section"` // source of the index the result comes from, as in filters, e.g. "nixos"
+	Source      string `json:"source"`  // source of the record, e.g. "nixpkgs" for NixOS options
+	Key         string `json:"key"`
+	Description string `json:"description"`
+
+	Broken     bool `json:"broken"`
+	Insecure   bool `json:"insecure"`
+	Vulnerable bool `json:"vulnerable"`
+
+	Score float64 `json:"score"` // relevance of the result for the query, see RankFunc
+
+	Highlights *Highlights       `json:"highlights,omitempty"` // set by Highlight
+	Alternates []PackageOrOption `json:"alternates,omitempty"` // set by GroupAlternates
+	Record     map[string]any    `json:"record,omitempty"`     // set by SelectFields
 }
 
-// packageRemoveNotMatching filters packages based on a regex pattern.
-// If onlyOnKey is true, the regex is matched only against the key.
-func packageRemoveNotMatching(i Packages, pattern string, onlyOnKey bool) Packages {
-	res := Packages{}
-
-	// Special search for maintainer
-	if strings.HasPrefix(pattern, "\\?maintainer=") {
-		pattern = strings.TrimPrefix(pattern, "\\?maintainer=")
-		for key, pkg := range i {
-			for _, maintainer := range pkg.Maintainers {
-				if strings.EqualFold(maintainer.GitHub, pattern) {
-					res[key] = pkg
-					break
-				}
-			}
+// packageOf returns the package behind a document of the search index.
+func (index Index) packageOf(d *document) (Package, bool) {
+	pkg, ok := index.Packages[d.section][d.key]
+	return pkg, ok
+}
+
+// optionOf returns the option behind a document of the search index.
+func (index Index) optionOf(d *document) (Option, bool) {
+	opt, ok := index.Options[d.section][d.key]
+	return opt, ok
+}
+
+// searchTerm is a search term of a query, ready to be matched.
+type searchTerm struct {
+	text   string // lowercased, without anchors
+	tokens []string
+	start  bool // anchored at the start of the key with "^"
+	end    bool // anchored at the end of the key with "$"
+	phrase bool
+	exact  bool
+}
+
+// newSearchTerm prepares a search term clause.
+// Terms starting with "^" or ending with "$" are anchored and only match against the key.
+func newSearchTerm(c Clause) searchTerm {
+	t := searchTerm{text: strings.ToLower(c.Value), phrase: c.Phrase, exact: c.Exact}
+	if !t.phrase {
+		t.text, t.start = strings.CutPrefix(t.text, "^")
+		t.text, t.end = strings.CutSuffix(t.text, "$")
+	}
+	t.tokens = tokenize(t.text)
+	return t
+}
+
+// onlyOnKey reports whether the term only matches against the key.
+func (t searchTerm) onlyOnKey() bool {
+	return t.start || t.end
+}
+
+// adjacent reports whether the tokens of the term must appear next to each other.
+func (t searchTerm) adjacent() bool {
+	return len(t.tokens) > 1 || t.phrase
+}
+
+// resolve returns the tokens of the vocabulary matching each token of the term,
+// and whether some of them were matched tolerating typos.
+// Typos are not tolerated in phrases, nor in anchored terms whose anchors
+// couldn't be checked otherwise. Exact terms only match whole tokens.
+func (s *searchIndex) resolve(t searchTerm) ([][]tokenMatch, bool) {
+	matches := make([][]tokenMatch, len(t.tokens))
+	fuzzy := false
+	for i, token := range t.tokens {
+		matches[i] = s.resolveToken(token, t.onlyOnKey(), !t.phrase && !t.onlyOnKey() && !t.exact)
+		if t.exact {
+			matches[i] = slices.DeleteFunc(matches[i], func(m tokenMatch) bool { return m.kind != matchExact })
 		}
-		return res
-	} else if strings.HasPrefix(pattern, "\\?broken") {
-		for key, pkg := range i {
-			if pkg.Broken {
-				res[key] = pkg
-			}
+		if len(matches[i]) > 0 && matches[i][0].kind == matchFuzzy {
+			fuzzy = true
 		}
-		return res
-	} else if strings.HasPrefix(pattern, "\\?vulnerable") {
-		for key, pkg := range i {
-			if pkg.Vulnerable {
-				res[key] = pkg
-			}
-		}
-		return res
 	}
+	return matches, fuzzy
+}
 
-	re, err := regexp.Compile("(?i)" + pattern)
-	if err != nil {
-		return i
+// matchSearchTerm returns the score of every document matching a search term.
+// The words of a phrase or of a term spanning several tokens (e.g. "nginx.enable")
+// must appear next to each other in the key or the description, unless they
+// contain a typo.
+func (s *searchIndex) matchSearchTerm(t searchTerm) map[int32]float64 {
+	if len(t.tokens) == 0 {
+		return nil
 	}
-	if onlyOnKey {
-		for key, pkg := range i {
-			if re.MatchString(key) {
-				res[key] = pkg
-			}
-		}
-	} else {
-		for key, pkg := range i {
-			// Combining source, literal " package " and key for matching
-			if re.MatchString(pkg.Source + " package " + key) {
-				res[key] = pkg
-			}
+
+	matches, fuzzy := s.resolve(t)
+	var scores map[int32]float64
+	for _, m := range matches {
+		scores = intersectScores(scores, s.scoreMatches(m, t.onlyOnKey()))
+	}
+	if fuzzy {
+		return scores
+	}
+
+	for id := range scores {
+		d := &s.docs[id]
+		switch {
+		case t.start && !strings.HasPrefix(d.lower, t.text),
+			t.end && !strings.HasSuffix(d.lower, t.text),
+			t.adjacent() && !strings.Contains(d.lower, t.text) &&
+				(t.onlyOnKey() || !strings.Contains(d.text, t.text)):
+			delete(scores, id)
 		}
 	}
-	return res
+	return scores
 }
 
-// optionRemoveNotMatching filters options based on a regex pattern.
-// If onlyOnKey is true, the regex is matched only against the key.
-func optionRemoveNotMatching(i Options, pattern string, onlyOnKey bool) Options {
-	res := Options{}
-	re, err := regexp.Compile("(?i)" + pattern)
-	if err != nil {
-		return i
+// intersectScores keeps the documents present in both a and b, summing their scores.
+// A nil a means that no term has been matched yet.
+func intersectScores(a, b map[int32]float64) map[int32]float64 {
+	if a == nil {
+		return b
 	}
-	if onlyOnKey {
-		for key, opt := range i {
-			if re.MatchString(key) {
-				res[key] = opt
-			}
-		}
-	} else {
-		for key, opt := range i {
-			// Combining source, literal " option " and key for matching
-			if re.MatchString(opt.Source + " option " + key) {
-				res[key] = opt
-			}
+	res := map[int32]float64{}
+	for id, score := range a {
+		if other, ok := b[id]; ok {
+			res[id] = score + other
 		}
 	}
 	return res
 }
 
-// removeNotMaching filters the entire index for both packages and options.
-func removeNotMaching(i Index, regex string, onlyOnKey bool) Index {
-	i.Nixpkgs = packageRemoveNotMatching(i.Nixpkgs, regex, onlyOnKey)
-	i.Nur = packageRemoveNotMatching(i.Nur, regex, onlyOnKey)
-	i.Nixos = optionRemoveNotMatching(i.Nixos, regex, onlyOnKey)
-	i.Homemanager = optionRemoveNotMatching(i.Homemanager, regex, onlyOnKey)
-	i.Darwin = optionRemoveNotMatching(i.Darwin, regex, onlyOnKey)
-	return i
-}
-
 // stripPrefix removes a possible prefix (e.g. "services." or "programs.") from the key.
 func stripPrefix(key string) string {
 	key = strings.TrimPrefix(key, "services.")
@@ -117,172 +142,175 @@ func stripPrefix(key string) string {
 	return key
 }
 
-// Search performs a search on the index based on the provided query and exclude list.
-// It returns a sorted slice of PackageOrOption results.
+// Search parses and performs a search query on the index, expanding its terms
+// to their synonyms. It returns a slice of PackageOrOption results, sorted by relevance.
+// Malformed queries have no results, use ParseQuery and SearchQuery to get the error.
 func (index Index) Search(query string) []PackageOrOption {
 	query = strings.TrimSpace(query)
 	if query == "" {
 		return nil
 	}
 
-	results := Index{}
+	q, err := ParseQuery(query)
+	if err != nil {
+		return []PackageOrOption{}
+	}
+	q, _ = index.ExpandSynonyms(q)
+	return index.SearchQuery(q, SearchOptions{})
+}
+
+// SearchOptions are the options of SearchQuery.
+type SearchOptions struct {
+	Rank          RankFunc      // relevance of the results, DefaultRank if nil
+	SourceWeights SourceWeights // multiply the relevance, DefaultSourceWeights if nil
 
-	fields := strings.Fields(query)
+	// Unsorted leaves the results in no particular order, to sort them later
+	// with Sort or SortTop, e.g. once filtered.
+	Unsorted bool
+}
 
-	if len(fields) == 0 {
-		return []PackageOrOption{}
+// SearchQuery performs a parsed search query on the index.
+// It returns a slice of PackageOrOption results, sorted by relevance unless
+// opts.Unsorted is set.
+func (index Index) SearchQuery(q Query, opts SearchOptions) []PackageOrOption {
+	rank := opts.Rank
+	if rank == nil {
+		rank = DefaultRank
 	}
+	weights := opts.SourceWeights
+	if weights == nil {
+		weights = DefaultSourceWeights
+	}
+
+	s := index.inverted()
 
-	if fields[0] == "package" {
-		results.Nixpkgs = index.Nixpkgs
-		results.Nur = index.Nur
-		results.Nixos = Options{}
-		results.Homemanager = Options{}
-		results.Darwin = Options{}
-	} else if fields[0] == "option" {
-		results.Nixpkgs = Packages{}
-		results.Nur = Packages{}
-		results.Nixos = index.Nixos
-		results.Homemanager = index.Homemanager
-		results.Darwin = index.Darwin
-	} else {
-		results.Nixpkgs = index.Nixpkgs
-		results.Nur = index.Nur
-		results.Nixos = index.Nixos
-		results.Homemanager = index.Homemanager
-		results.Darwin = index.Darwin
+	scores := map[int32]float64{}
+	if !q.IsEmpty() {
+		scores = index.eval(s, q.Root, nil)
 	}
 
-	// Exclude sections if specified.
-	if slices.Contains(fields, "!nixos") {
-		results.Nixos = Options{}
-		fields = slices.Delete(
-			fields,
-			slices.Index(fields, "!nixos"),
-			slices.Index(fields, "!nixos")+1,
-		)
+	items := make([]PackageOrOption, 0, len(scores))
+	for id, score := range scores {
+		item := index.item(&s.docs[id], score)
+		item.Score = rank(item) * weights.weight(item.Section)
+		items = append(items, item)
 	}
-	if slices.Contains(fields, "!nixpkgs") {
-		results.Nixpkgs = Packages{}
-		fields = slices.Delete(
-			fields,
-			slices.Index(fields, "!nixpkgs"),
-			slices.Index(fields, "!nixpkgs")+1,
-		)
+	if !opts.Unsorted {
+		index.Sort(items, SortOrder{By: SortRelevance, Descending: true})
 	}
-	if slices.Contains(fields, "!nur") {
-		results.Nur = Packages{}
-		fields = slices.Delete(fields, slices.Index(fields, "!nur"), slices.Index(fields, "!nur")+1)
+
+	return items
+}
+
+// item returns the search result of a document.
+func (index Index) item(d *document, score float64) PackageOrOption {
+	item := PackageOrOption{
+		Type:    string(d.kind),
+		Source:  d.source,
+		Key:     d.key,
+		Score:   score,
+		Section: d.section,
 	}
-	if slices.Contains(fields, "!home-manager") {
-		results.Homemanager = Options{}
-		fields = slices.Delete(
-			fields,
-			slices.Index(fields, "!home-manager"),
-			slices.Index(fields, "!home-manager")+1,
-		)
+	if pkg, ok := index.packageOf(d); ok {
+		item.Description = pkg.Description
+		item.Broken = pkg.Broken
+		item.Insecure = pkg.Insecure
+		item.Vulnerable = pkg.Vulnerable
+	} else if opt, ok := index.optionOf(d); ok {
+		item.Description = opt.Description
 	}
-	if slices.Contains(fields, "!darwin") {
-		results.Darwin = Options{}
-		fields = slices.Delete(
-			fields,
-			slices.Index(fields, "!darwin"),
-			slices.Index(fields, "!darwin")+1,
-		)
+	return item
+}
+
+// lessByKeyLength orders results by the length of their key after stripping the prefix.
+func lessByKeyLength(a, b PackageOrOption) bool {
+	keyA := stripPrefix(a.Key)
+	keyB := stripPrefix(b.Key)
+	if len(keyA) == len(keyB) {
+		return a.Key < b.Key
 	}
+	return len(keyA) < len(keyB)
+}
 
-	// Process each search term (using Fields to handle spaces efficiently).
-	for _, term := range fields {
-		regex := ""
-		onlyOnKey := false
-		endWith := ""
-		if strings.HasPrefix(term, "^") {
-			term = strings.TrimPrefix(term, "^")
-			regex += "^"
-			onlyOnKey = true
+// eval returns the documents matching a node of a query, with their scores.
+// Only the documents in within are considered, or all of them if within is nil.
+func (index Index) eval(s *searchIndex, n Node, within map[int32]float64) map[int32]float64 {
+	if n.Negated {
+		n.Negated = false
+		matching := index.eval(s, n, within)
+		res := map[int32]float64{}
+		s.forEach(within, func(id int32) {
+			if _, ok := matching[id]; !ok {
+				res[id] = 0
+			}
+		})
+		return res
+	}
+
+	switch n.Operator {
+	case OperatorAnd:
+		// Terms narrow down the documents the most, filters and negations come last.
+		children := slices.Clone(n.Children)
+		slices.SortStableFunc(children, func(a, b Node) int {
+			return evalOrder(a) - evalOrder(b)
+		})
+		res := within
+		for _, child := range children {
+			res = intersectScores(res, index.eval(s, child, res))
 		}
-		if strings.HasSuffix(term, "$") {
-			term = strings.TrimSuffix(term, "$")
-			endWith = "$"
-			onlyOnKey = true
+		if res == nil {
+			res = map[int32]float64{}
 		}
-		regex += regexp.QuoteMeta(term) + endWith
-		results = removeNotMaching(results, regex, onlyOnKey)
-	}
+		return res
 
-	// Combine packages and options into a single slice.
-	var items []PackageOrOption
-	for key, opt := range results.Nixos {
-		items = append(
-			items,
-			PackageOrOption{
-				Type:        "option",
-				Source:      opt.Source,
-				Key:         key,
-				Description: opt.Description,
-			},
-		)
-	}
-	for key, opt := range results.Homemanager {
-		items = append(
-			items,
-			PackageOrOption{
-				Type:        "option",
-				Source:      opt.Source,
-				Key:         key,
-				Description: opt.Description,
-			},
-		)
+	case OperatorOr:
+		res := map[int32]float64{}
+		for _, child := range n.Children {
+			for id, score := range index.eval(s, child, within) {
+				res[id] = max(res[id], score)
+			}
+		}
+		return res
 	}
-	for key, opt := range results.Darwin {
-		items = append(
-			items,
-			PackageOrOption{
-				Type:        "option",
-				Source:      opt.Source,
-				Key:         key,
-				Description: opt.Description,
-			},
-		)
+
+	res := map[int32]float64{}
+	if n.Clause.Qualifier != "" {
+		filter := index.filter(n.Clause)
+		s.forEach(within, func(id int32) {
+			if filter(&s.docs[id]) {
+				res[id] = 0
+			}
+		})
+		return res
 	}
-	for key, pkg := range results.Nixpkgs {
-		items = append(
-			items,
-			PackageOrOption{
-				Type:        "package",
-				Source:      pkg.Source,
-				Key:         key,
-				Description: pkg.Description,
-				Broken:      pkg.Broken,
-				Insecure:    pkg.Insecure,
-				Vulnerable:  pkg.Vulnerable,
-			},
-		)
+	for id, score := range s.matchSearchTerm(newSearchTerm(n.Clause)) {
+		if _, ok := within[id]; ok || within == nil {
+			res[id] = score
+		}
 	}
-	for key, pkg := range results.Nur {
-		items = append(
-			items,
-			PackageOrOption{
-				Type:        "package",
-				Source:      pkg.Source,
-				Key:         key,
-				Description: pkg.Description,
-				Broken:      pkg.Broken,
-				Insecure:    pkg.Insecure,
-				Vulnerable:  pkg.Vulnerable,
-			},
-		)
+	return res
+}
+
+// evalOrder returns the rank of a node in the evaluation order of its AND group.
+func evalOrder(n Node) int {
+	switch {
+	case n.Negated:
+		return 2
+	case n.Operator == OperatorClause && n.Clause.Qualifier != "":
+		return 1
 	}
+	return 0
+}
 
-	// Naive sorting by the length of the key after stripping the prefix.
-	sort.Slice(items, func(i, j int) bool {
-		keyI := stripPrefix(items[i].Key)
-		keyJ := stripPrefix(items[j].Key)
-		if len(keyI) == len(keyJ) {
-			return items[i].Key < items[j].Key
+// forEach calls fn for every document in within, or every document if within is nil.
+func (s *searchIndex) forEach(within map[int32]float64, fn func(id int32)) {
+	if within == nil {
+		for id := range s.docs {
+			fn(int32(id))
 		}
-		return len(keyI) < len(keyJ)
-	})
-
-	return items
+		return
+	}
+	for id := range within {
+		fn(id)
+	}
 }
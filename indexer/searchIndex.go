@@ -0,0 +1,355 @@
+package indexer
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 tuning parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Fields of a document that are indexed.
+const (
+	fieldKey = iota
+	fieldDescription
+	fieldLongDescription
+	fieldType // option type
+	fieldMeta
+	fieldCount
+)
+
+// fieldWeights is the weight of a match in each field.
+// Matches in the key always weigh more than matches in the texts describing it.
+var fieldWeights = [fieldCount]float64{
+	fieldKey:             1.0,
+	fieldDescription:     0.4,
+	fieldLongDescription: 0.2,
+	fieldType:            0.2,
+	fieldMeta:            0.1,
+}
+
+// isTextField reports whether a field holds free text rather than an identifier.
+// Tokens of text fields only match on their beginning: "go" should not match "good".
+func isTextField(field uint8) bool {
+	return field == fieldDescription || field == fieldLongDescription
+}
+
+// Kinds of token matches, from the strongest to the weakest.
+const (
+	matchExact     = iota // the token is the term
+	matchPrefix           // the token starts with the term
+	matchSubstring        // the token contains the term
+	matchFuzzy            // the token is within a few edits of the term
+)
+
+// matchWeights is the weight of each kind of token match.
+// Fuzzy matches are further divided by their number of edits.
+var matchWeights = [...]float64{
+	matchExact:     1.0,
+	matchPrefix:    0.5,
+	matchSubstring: 0.25,
+	matchFuzzy:     0.2,
+}
+
+// tokenMatch is a token of the vocabulary matching a token of a search term.
+type tokenMatch struct {
+	token  string
+	kind   int
+	weight float64
+}
+
+// allowedIn reports whether the match counts in a field.
+// Tokens of text fields never match on a substring, and typos are only
+// tolerated in keys.
+func (m tokenMatch) allowedIn(field uint8) bool {
+	switch m.kind {
+	case matchSubstring:
+		return !isTextField(field)
+	case matchFuzzy:
+		return field == fieldKey
+	}
+	return true
+}
+
+// document is an entry (package or option) of the search index.
+type document struct {
+	kind    SourceKind
+	section string // name of the source
+	source  string
+	key     string
+	lower   string // lowercased key
+	text    string // lowercased description and long description
+
+	length [fieldCount]int
+}
+
+type posting struct {
+	doc   int32
+	field uint8
+	tf    uint16
+}
+
+// searchIndex is an inverted index over the keys and descriptions of an Index.
+// It is built once when the index is loaded.
+type searchIndex struct {
+	docs        []document
+	postings    map[string][]posting
+	df          map[string]int     // number of documents containing each token
+	vocab       []string           // sorted tokens
+	trigrams    map[string][]int32 // trigram -> tokens of the vocabulary containing it
+	programs    map[string][]int32 // lowercased main program -> packages providing it
+	maintainers map[string][]int32 // maintainer, see maintainerID -> packages maintained
+	sorted      []int32            // documents sorted by lowercased key
+	trees       map[string]*optionTree
+	norms       []float64 // norms of the description vectors, see Similar
+
+	avgLength [fieldCount]float64
+}
+
+// tokenize splits a text into lowercased tokens.
+// Attribute paths are split on ".", "-", "_" and any other non alphanumeric character.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// buildSearchIndex builds the inverted index of the packages and options of the index.
+func buildSearchIndex(index Index) *searchIndex {
+	s := &searchIndex{
+		postings:    map[string][]posting{},
+		df:          map[string]int{},
+		programs:    map[string][]int32{},
+		maintainers: map[string][]int32{},
+		trees:       map[string]*optionTree{},
+		trigrams:    map[string][]int32{},
+	}
+
+	addOptions := func(section string, options Options) {
+		s.trees[section] = &optionTree{}
+		for key, opt := range options {
+			s.trees[section].insert(key)
+			d := document{kind: KindOption, section: section, source: opt.Source, key: key}
+			s.add(d, opt.Description, "", opt.Type)
+		}
+	}
+	addPackages := func(section string, packages Packages) {
+		for key, pkg := range packages {
+			d := document{kind: KindPackage, section: section, source: pkg.Source, key: key}
+			if pkg.MainProgram != "" {
+				program := strings.ToLower(pkg.MainProgram)
+				s.programs[program] = append(s.programs[program], int32(len(s.docs)))
+			}
+			for _, m := range pkg.Maintainers {
+				name := maintainerID(m)
+				docs := s.maintainers[name]
+				if name != "" && (len(docs) == 0 || docs[len(docs)-1] != int32(len(s.docs))) {
+					s.maintainers[name] = append(docs, int32(len(s.docs)))
+				}
+			}
+			s.add(d, pkg.Description, pkg.LongDescription, "")
+		}
+	}
+	for _, source := range sources {
+		switch source.Kind() {
+		case KindPackage:
+			addPackages(source.Name(), index.Packages[source.Name()])
+		case KindOption:
+			addOptions(source.Name(), index.Options[source.Name()])
+		}
+	}
+
+	total := [fieldCount]int{}
+	for _, d := range s.docs {
+		for f := range fieldCount {
+			total[f] += d.length[f]
+		}
+	}
+	for f := range fieldCount {
+		if len(s.docs) > 0 {
+			s.avgLength[f] = float64(total[f]) / float64(len(s.docs))
+		}
+	}
+
+	s.vocab = make([]string, 0, len(s.postings))
+	for token := range s.postings {
+		s.vocab = append(s.vocab, token)
+	}
+	sort.Strings(s.vocab)
+	for id, token := range s.vocab {
+		for i := 0; i+minSubstring <= len(token); i++ {
+			gram := token[i : i+minSubstring]
+			tokens := s.trigrams[gram]
+			if len(tokens) == 0 || tokens[len(tokens)-1] != int32(id) {
+				s.trigrams[gram] = append(tokens, int32(id))
+			}
+		}
+	}
+	s.buildSimilarity()
+
+	s.sorted = make([]int32, len(s.docs))
+	for i := range s.sorted {
+		s.sorted[i] = int32(i)
+	}
+	sort.Slice(s.sorted, func(i, j int) bool {
+		a, b := &s.docs[s.sorted[i]], &s.docs[s.sorted[j]]
+		if a.lower != b.lower {
+			return a.lower < b.lower
+		}
+		return a.section < b.section
+	})
+
+	return s
+}
+
+// add indexes a document along with the texts describing it.
+func (s *searchIndex) add(d document, description, longDescription, optionType string) {
+	d.lower = strings.ToLower(d.key)
+	d.text = strings.ToLower(description + "\n" + longDescription)
+	id := int32(len(s.docs))
+	fields := [fieldCount][]string{
+		fieldKey:             tokenize(d.key),
+		fieldDescription:     tokenize(description),
+		fieldLongDescription: tokenize(longDescription),
+		fieldType:            tokenize(optionType),
+		fieldMeta:            {strings.ToLower(d.source), string(d.kind)},
+	}
+	seen := map[string]bool{}
+	for f, tokens := range fields {
+		d.length[f] = len(tokens)
+		tf := map[string]uint16{}
+		for _, t := range tokens {
+			tf[t]++
+		}
+		for t, n := range tf {
+			s.postings[t] = append(s.postings[t], posting{doc: id, field: uint8(f), tf: n})
+			if !seen[t] {
+				seen[t] = true
+				s.df[t]++
+			}
+		}
+	}
+	s.docs = append(s.docs, d)
+}
+
+// idf returns the inverse document frequency of a token.
+func (s *searchIndex) idf(token string) float64 {
+	n := float64(len(s.docs))
+	df := float64(s.df[token])
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// bm25 returns the BM25 score of a posting.
+func (s *searchIndex) bm25(p posting, idf float64) float64 {
+	d := &s.docs[p.doc]
+	tf := float64(p.tf)
+	norm := 1 - bm25B
+	if s.avgLength[p.field] > 0 {
+		norm += bm25B * float64(d.length[p.field]) / s.avgLength[p.field]
+	}
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+}
+
+// minSubstring is the length of the terms looked up in the trigrams of the
+// vocabulary to match the tokens containing them.
+const minSubstring = 3
+
+// matchingTokens returns the tokens equal to, starting with or containing a term.
+// Shorter terms than minSubstring only match inside tokens when no token
+// starts with them, as they are found in most of the vocabulary.
+func (s *searchIndex) matchingTokens(term string) []tokenMatch {
+	matches := []tokenMatch{}
+	add := func(token string, kind int) {
+		matches = append(matches, tokenMatch{token: token, kind: kind, weight: matchWeights[kind]})
+	}
+
+	// The tokens starting with the term follow it in the sorted vocabulary
+	for _, token := range s.vocab[sort.SearchStrings(s.vocab, term):] {
+		if !strings.HasPrefix(token, term) {
+			break
+		}
+		if token == term {
+			add(token, matchExact)
+		} else {
+			add(token, matchPrefix)
+		}
+	}
+
+	if len(term) < minSubstring {
+		if len(matches) == 0 {
+			for _, token := range s.vocab {
+				if strings.Contains(token, term) {
+					add(token, matchSubstring)
+				}
+			}
+		}
+		return matches
+	}
+	// The tokens containing the term have all its trigrams, check those having the rarest one
+	candidates := s.trigrams[term[:minSubstring]]
+	for i := 1; i+minSubstring <= len(term); i++ {
+		if tokens := s.trigrams[term[i:i+minSubstring]]; len(tokens) < len(candidates) {
+			candidates = tokens
+		}
+	}
+	for _, id := range candidates {
+		token := s.vocab[id]
+		if !strings.HasPrefix(token, term) && strings.Contains(token, term) {
+			add(token, matchSubstring)
+		}
+	}
+	return matches
+}
+
+// resolveToken returns the tokens of the vocabulary matching a token of a search term.
+// If none of them is in a document, and fuzzy is true, the closest tokens are
+// returned instead, tolerating typos.
+// If onlyOnKey is true, only the key field is considered.
+func (s *searchIndex) resolveToken(token string, onlyOnKey, fuzzy bool) []tokenMatch {
+	matches := s.matchingTokens(token)
+	for _, m := range matches {
+		for _, p := range s.postings[m.token] {
+			if m.allowedIn(p.field) && (!onlyOnKey || p.field == fieldKey) {
+				return matches
+			}
+		}
+	}
+	if !fuzzy {
+		return matches
+	}
+	return s.closestTokens(token)
+}
+
+// scoreMatches returns the score of every document containing one of the matched tokens.
+// A document matching several tokens gets the score of its best match.
+// If onlyOnKey is true, only the key field is considered.
+func (s *searchIndex) scoreMatches(matches []tokenMatch, onlyOnKey bool) map[int32]float64 {
+	// Tokens merely starting with or containing the term are rarer than the
+	// term itself, which must not make them more relevant ("firefoxpwa" for "firefox")
+	maxIDF := math.Inf(1)
+	for _, m := range matches {
+		if m.kind == matchExact {
+			maxIDF = s.idf(m.token)
+		}
+	}
+
+	scores := map[int32]float64{}
+	for _, m := range matches {
+		idf := min(s.idf(m.token), maxIDF)
+		for _, p := range s.postings[m.token] {
+			if (onlyOnKey && p.field != fieldKey) || !m.allowedIn(p.field) {
+				continue
+			}
+			score := m.weight * fieldWeights[p.field] * s.bm25(p, idf)
+			if score > scores[p.doc] {
+				scores[p.doc] = score
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,89 @@
+package indexer
+
+import (
+	"slices"
+	"testing"
+)
+
+// searchFixture returns a small index to test the ranking of search results.
+func searchFixture() Index {
+	return NewIndex(nil, map[string]Packages{
+		"nixpkgs": {
+			"firefox":                  {Source: "nixpkgs", Description: "Web browser built from Firefox source tree"},
+			"firefox-unwrapped":        {Source: "nixpkgs", Description: "Web browser built from Firefox source tree"},
+			"firefox-unwrapped-addons": {Source: "nixpkgs", Description: "Addons for the unwrapped Firefox"},
+			"firefoxpwa":               {Source: "nixpkgs", Description: "Tool to install, manage and use Progressive Web Apps in Mozilla Firefox"},
+			"tridactyl-native":         {Source: "nixpkgs", Description: "Tridactyl native messaging host application, for Firefox"},
+			"zathura":                  {Source: "nixpkgs", Description: "Highly customizable and functional PDF viewer"},
+			"mupdf":                    {Source: "nixpkgs", Description: "Lightweight PDF, XPS, and E-book viewer and toolkit written in portable C"},
+			"evince": {
+				Source:          "nixpkgs",
+				Description:     "GNOME's document viewer",
+				LongDescription: "Evince is a document viewer for multiple document formats. It currently supports PDF, PostScript, DjVu, TIFF and DVI.",
+			},
+			"poppler_utils":            {Source: "nixpkgs", Description: "PDF rendering library, utilities"},
+			"python3Packages.pdfminer": {Source: "nixpkgs", Description: "PDF parser and analyzer"},
+			"ripgrep":                  {Source: "nixpkgs", Description: "Utility that combines the usability of The Silver Searcher with the raw speed of grep"},
+			"ripgrep-all":              {Source: "nixpkgs", Description: "Ripgrep, but also search in PDFs, E-Books, Office documents, zip, tar.gz, and more"},
+			"ugrep":                    {Source: "nixpkgs", Description: "Ultra fast grep with interactive query UI"},
+		},
+		"nur": {
+			"repos.x.firefox": {Source: "nur", Description: "Web browser built from Firefox source tree"},
+		},
+	}, map[string]Options{
+		"home-manager": {
+			"programs.firefox.enable":  {Source: "home-manager", Type: "boolean", Description: "Whether to enable Firefox."},
+			"programs.firefox.package": {Source: "home-manager", Type: "package", Description: "The Firefox package to use."},
+		},
+	})
+}
+
+func TestSearchRanking(t *testing.T) {
+	index := searchFixture()
+	tests := []struct {
+		query string
+		want  []string // in this order, before the other results
+	}{
+		// Whole tokens of the key first, variants after the package, then
+		// prefixes, descriptions and the NUR
+		{"firefox", []string{
+			"firefox", "firefox-unwrapped", "programs.firefox.enable", "programs.firefox.package",
+			"firefox-unwrapped-addons", "firefoxpwa", "tridactyl-native", "repos.x.firefox",
+		}},
+		{"ripgrep", []string{"ripgrep", "ripgrep-all"}},
+		// Shorter descriptions first
+		{"viewer", []string{"evince", "zathura", "mupdf"}},
+		{"pdf viewer", []string{"zathura", "mupdf", "evince"}},
+		// Long descriptions and prefixes of description words last
+		{"pdf", []string{"poppler_utils", "python3Packages.pdfminer", "zathura", "mupdf", "ripgrep-all", "evince"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := keys(index.Search(tt.query))
+			if len(got) < len(tt.want) || !slices.Equal(got[:len(tt.want)], tt.want) {
+				t.Errorf("Search(%q) = %v, want %v first", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchCommonToken(t *testing.T) {
+	// "nginx" is in the key and the description of every document
+	index := NewIndex(nil, nil, map[string]Options{
+		"nixos": {
+			"services.nginx.enable":  {Source: "nixpkgs", Type: "boolean", Description: "Whether to enable Nginx Web Server."},
+			"services.nginx.package": {Source: "nixpkgs", Type: "package", Description: "Nginx package to use."},
+		},
+	})
+	if got := sortedKeys(index.Search("nginx")); !slices.Equal(got, []string{"services.nginx.enable", "services.nginx.package"}) {
+		t.Errorf("Search(%q) = %v, want both options", "nginx", got)
+	}
+}
+
+func TestNewIndexBuildsOnce(t *testing.T) {
+	index := NewIndex(nil, map[string]Packages{"nixpkgs": {"firefox": {Source: "nixpkgs"}}}, nil)
+	copied := index
+	if index.inverted() != copied.inverted() || index.references() != copied.references() {
+		t.Error("the copies of an index don't share its search structures")
+	}
+}
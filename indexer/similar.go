@@ -0,0 +1,147 @@
+package indexer
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Tuning of the similarity between packages.
+const (
+	// similarTokens is the number of most significant tokens of a description compared,
+	// common words weighing too little to be among them.
+	similarTokens = 32
+	// maintainersWeight is the weight of the shared maintainers, the descriptions weighing the rest.
+	maintainersWeight = 0.25
+	// MaxSimilar is the maximum number of similar packages returned.
+	MaxSimilar = 50
+)
+
+// tokenWeight is the TF-IDF weight of a token in a document.
+type tokenWeight struct {
+	token  string
+	weight float64
+}
+
+// descriptionWeights calls fn with the TF-IDF weight of a token in the descriptions
+// of every package containing it.
+func (s *searchIndex) descriptionWeights(token string, fn func(doc int32, weight float64)) {
+	idf := s.idf(token)
+	postings := s.postings[token]
+	for i := 0; i < len(postings); {
+		// The postings of a document are next to each other, one per field.
+		doc := postings[i].doc
+		weight := 0.0
+		for ; i < len(postings) && postings[i].doc == doc; i++ {
+			if p := postings[i]; isTextField(p.field) {
+				weight += fieldWeights[p.field] * (1 + math.Log(float64(p.tf)))
+			}
+		}
+		if weight > 0 && s.docs[doc].kind == KindPackage {
+			fn(doc, weight*idf)
+		}
+	}
+}
+
+// buildSimilarity precomputes the norms of the description vectors of the packages.
+func (s *searchIndex) buildSimilarity() {
+	s.norms = make([]float64, len(s.docs))
+	for _, token := range s.vocab {
+		s.descriptionWeights(token, func(doc int32, weight float64) {
+			s.norms[doc] += weight * weight
+		})
+	}
+	for i := range s.norms {
+		s.norms[i] = math.Sqrt(s.norms[i])
+	}
+}
+
+// maintainerID returns the identifier of a maintainer, to find the packages they share.
+func maintainerID(m Maintainer) string {
+	if m.GitHub != "" {
+		return strings.ToLower(m.GitHub)
+	}
+	return strings.ToLower(m.Name)
+}
+
+// lookup returns the document of a key in a section of the index.
+func (s *searchIndex) lookup(section, key string) (int32, bool) {
+	lower := strings.ToLower(key)
+	i := sort.Search(len(s.sorted), func(i int) bool {
+		return s.docs[s.sorted[i]].lower >= lower
+	})
+	for ; i < len(s.sorted) && s.docs[s.sorted[i]].lower == lower; i++ {
+		if d := &s.docs[s.sorted[i]]; d.section == section && d.key == key {
+			return s.sorted[i], true
+		}
+	}
+	return 0, false
+}
+
+// Similar returns the packages of every source most related to a package,
+// by the cosine similarity of the TF-IDF vectors of their descriptions and by
+// their shared maintainers. Results are sorted by decreasing similarity, in Score.
+func (index Index) Similar(section, key string, limit int) ([]PackageOrOption, error) {
+	if source, ok := SourceByName(section); !ok || source.Kind() != KindPackage {
+		return nil, ErrUnknownSource
+	}
+	s := index.inverted()
+	id, ok := s.lookup(section, key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	pkg, _ := index.packageOf(&s.docs[id])
+
+	// Weights of the most significant tokens of the description of the package.
+	weights := []tokenWeight{}
+	seen := map[string]bool{}
+	for _, token := range tokenize(pkg.Description + "\n" + pkg.LongDescription) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		s.descriptionWeights(token, func(doc int32, weight float64) {
+			if doc == id {
+				weights = append(weights, tokenWeight{token: token, weight: weight})
+			}
+		})
+	}
+	sort.Slice(weights, func(i, j int) bool { return weights[i].weight > weights[j].weight })
+	weights = weights[:min(len(weights), similarTokens)]
+
+	scores := map[int32]float64{}
+	if s.norms[id] > 0 {
+		for _, w := range weights {
+			s.descriptionWeights(w.token, func(doc int32, weight float64) {
+				scores[doc] += w.weight * weight / (s.norms[id] * s.norms[doc])
+			})
+		}
+		for doc := range scores {
+			scores[doc] *= 1 - maintainersWeight
+		}
+	}
+
+	maintainers := []string{}
+	for _, m := range pkg.Maintainers {
+		if name := maintainerID(m); name != "" && !slices.Contains(maintainers, name) {
+			maintainers = append(maintainers, name)
+		}
+	}
+	// Maintainers of many packages say less about them.
+	for _, m := range maintainers {
+		docs := s.maintainers[m]
+		weight := maintainersWeight / float64(len(maintainers)) / (1 + math.Log(float64(len(docs))))
+		for _, doc := range docs {
+			scores[doc] += weight
+		}
+	}
+	delete(scores, id)
+
+	items := []PackageOrOption{}
+	for doc, score := range scores {
+		items = append(items, index.item(&s.docs[doc], score))
+	}
+	index.Sort(items, SortOrder{By: SortRelevance, Descending: true})
+	return items[:min(len(items), limit)], nil
+}
@@ -0,0 +1,241 @@
+package indexer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Sort orders of search results.
+const (
+	SortRelevance    = "relevance"    // most relevant first
+	SortAlphabetical = "alphabetical" // by key
+	SortLength       = "length"       // by key length
+	SortSource       = "source"       // by source priority, see RegisterSource
+	SortVersion      = "version"      // by package version, options last
+)
+
+// SortOrder is an order of search results.
+type SortOrder struct {
+	By         string
+	Descending bool
+}
+
+// ParseSortOrder parses a sort order, such as "version" or "version:asc".
+// Without a direction, relevance and version are descending, and the other
+// orders are ascending.
+func ParseSortOrder(s string) (SortOrder, error) {
+	by, direction, _ := strings.Cut(s, ":")
+	order := SortOrder{By: by}
+	switch by {
+	case "", SortRelevance:
+		order.By = SortRelevance
+		order.Descending = true
+	case SortVersion:
+		order.Descending = true
+	case SortAlphabetical, SortLength, SortSource:
+	default:
+		return SortOrder{}, fmt.Errorf(
+			"unknown sort order %q, expected one of %s",
+			by,
+			strings.Join([]string{SortRelevance, SortAlphabetical, SortLength, SortSource, SortVersion}, ", "),
+		)
+	}
+	switch direction {
+	case "":
+	case "asc":
+		order.Descending = false
+	case "desc":
+		order.Descending = true
+	default:
+		return SortOrder{}, fmt.Errorf("unknown sort direction %q, expected asc or desc", direction)
+	}
+	return order, nil
+}
+
+// RankFunc returns the relevance of a result, given the score of its text
+// match in the Score field.
+type RankFunc func(item PackageOrOption) float64
+
+// DefaultRank is the default RankFunc. Starting from the text match score, it
+// favors top-level attributes over nested ones, and packages over their variants
+// and wrappers.
+func DefaultRank(item PackageOrOption) float64 {
+	score := item.Score
+	score /= 1 + 0.1*float64(strings.Count(stripPrefix(item.Key), "."))
+	if item.Type == "package" && isVariant(item.Key) {
+		score *= 0.9
+	}
+	return score
+}
+
+// Sort sorts results in the given order. Ties are broken by relevance, then by key.
+func (index Index) Sort(items []PackageOrOption, order SortOrder) {
+	index.SortTop(items, order, len(items))
+}
+
+// SortTop sorts the first n results in the given order, and moves the others
+// after them in no particular order. It is cheaper than Sort when only the
+// first page of the results is needed.
+func (index Index) SortTop(items []PackageOrOption, order SortOrder, n int) {
+	var versions map[string]string
+	if order.By == SortVersion {
+		versions = map[string]string{}
+		for _, item := range items {
+			if pkg, ok := index.packageOf(&document{section: item.Section, key: item.Key}); ok {
+				versions[item.Section+"/"+item.Key] = pkg.Version
+			}
+		}
+	}
+
+	priority := SourceNames()
+	compare := func(a, b PackageOrOption) int {
+		switch order.By {
+		case SortAlphabetical:
+			return cmp.Compare(a.Key, b.Key)
+		case SortLength:
+			return cmp.Compare(len(a.Key), len(b.Key))
+		case SortSource:
+			return cmp.Compare(
+				slices.Index(priority, a.Section),
+				slices.Index(priority, b.Section),
+			)
+		case SortVersion:
+			return compareVersions(versions[a.Section+"/"+a.Key], versions[b.Section+"/"+b.Key])
+		}
+		return cmp.Compare(a.Score, b.Score)
+	}
+
+	partialSort(items, min(max(n, 0), len(items)), func(a, b PackageOrOption) int {
+		// Results without a version always come last.
+		if order.By == SortVersion {
+			va, vb := versions[a.Section+"/"+a.Key], versions[b.Section+"/"+b.Key]
+			if (va == "") != (vb == "") {
+				if va == "" {
+					return 1
+				}
+				return -1
+			}
+		}
+		c := compare(a, b)
+		if order.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		if lessByKeyLength(a, b) {
+			return -1
+		}
+		if lessByKeyLength(b, a) {
+			return 1
+		}
+		return cmp.Compare(a.Section, b.Section)
+	})
+}
+
+// partialSort sorts the n smallest items first, and leaves the others after them
+// in no particular order. compare must be a total order, as it is not stable.
+func partialSort[T any](items []T, n int, compare func(a, b T) int) {
+	// Quickselect the n smallest items, then sort them
+	lo, hi := 0, len(items)
+	for lo < n && n < hi {
+		p := lo + partition(items[lo:hi], compare)
+		if p < n {
+			lo = p + 1
+		} else {
+			hi = p
+		}
+	}
+	slices.SortFunc(items[:n], compare)
+}
+
+// partition moves the items lower than a pivot before it, and the others after
+// it. It returns the position of the pivot.
+func partition[T any](items []T, compare func(a, b T) int) int {
+	last := len(items) - 1
+	items[len(items)/2], items[last] = items[last], items[len(items)/2]
+	i := 0
+	for j := range last {
+		if compare(items[j], items[last]) < 0 {
+			items[i], items[j] = items[j], items[i]
+			i++
+		}
+	}
+	items[i], items[last] = items[last], items[i]
+	return i
+}
+
+// compareVersions compares two versions like nix's builtins.compareVersions:
+// versions are split into numeric and alphabetic components, numbers are compared
+// numerically and are greater than words, and "pre" is lower than anything.
+// Missing components are empty, which is lower than a number.
+func compareVersions(a, b string) int {
+	ca, cb := versionComponents(a), versionComponents(b)
+	for i := 0; i < len(ca) || i < len(cb); i++ {
+		x, y := "", ""
+		if i < len(ca) {
+			x = ca[i]
+		}
+		if i < len(cb) {
+			y = cb[i]
+		}
+		if versionComponentLess(x, y) {
+			return -1
+		}
+		if versionComponentLess(y, x) {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionComponentLess(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return na < nb
+	case a == "" && errB == nil:
+		return true
+	case a == "pre" && b != "pre":
+		return true
+	case b == "pre":
+		return false
+	case errA == nil:
+		return false
+	case errB == nil:
+		return true
+	}
+	return a < b
+}
+
+// versionComponents splits a version on dots and dashes, and between digits and letters.
+func versionComponents(version string) []string {
+	components := []string{}
+	current := []rune{}
+	flush := func() {
+		if len(current) > 0 {
+			components = append(components, string(current))
+			current = current[:0]
+		}
+	}
+	for _, r := range version {
+		if r == '.' || r == '-' {
+			flush()
+			continue
+		}
+		if len(current) > 0 && unicode.IsDigit(current[len(current)-1]) != unicode.IsDigit(r) {
+			flush()
+		}
+		current = append(current, r)
+	}
+	flush()
+	return components
+}
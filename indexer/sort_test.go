@@ -0,0 +1,83 @@
+package indexer
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"", "", 0},
+		{"1.0", "2.3", -1},
+		{"2.3", "1.0", 1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3a", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3pre1", 1},
+		{"2.3", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"1.10", "1.9", 1},
+		{"1-0", "1.0", 0},
+		{"20240101", "20231231", 1},
+		{"1.0", "", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestSortTop(t *testing.T) {
+	items := []PackageOrOption{}
+	for i := range 200 {
+		items = append(items, PackageOrOption{
+			Type:    "package",
+			Section: "nixpkgs",
+			Key:     "pkg" + strconv.Itoa(i*7919%200),
+			Score:   float64(i * 31 % 13),
+		})
+	}
+	order := SortOrder{By: SortRelevance, Descending: true}
+	want := slices.Clone(items)
+	Index{}.Sort(want, order)
+
+	for _, n := range []int{-1, 0, 1, 20, 199, 200, 1000} {
+		got := slices.Clone(items)
+		Index{}.SortTop(got, order, n)
+		top := min(max(n, 0), len(items))
+		if !slices.Equal(keys(got[:top]), keys(want[:top])) {
+			t.Errorf("SortTop(%d) = %v, want %v", n, keys(got[:top]), keys(want[:top]))
+		}
+		if !slices.Equal(sortedKeys(got), sortedKeys(want)) {
+			t.Errorf("SortTop(%d) lost or duplicated results", n)
+		}
+	}
+}
+
+func keys(items []PackageOrOption) []string {
+	res := []string{}
+	for _, item := range items {
+		res = append(res, item.Key)
+	}
+	return res
+}
+
+func sortedKeys(items []PackageOrOption) []string {
+	res := keys(items)
+	slices.Sort(res)
+	return res
+}
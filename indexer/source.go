@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SourceKind is the kind of entries a source provides.
+type SourceKind string
+
+const (
+	KindPackage SourceKind = "package"
+	KindOption  SourceKind = "option"
+)
+
+// Data is the normalized data of a source: packages or options, depending on its kind.
+type Data struct {
+	Packages Packages
+	Options  Options
+}
+
+// Source is an ecosystem indexed by the API, such as nixpkgs or Home Manager.
+// Sources are registered with RegisterSource, and the index, the search and
+// the routes iterate over them.
+type Source interface {
+	// Name identifies the source in the index, the source: qualifier and the stats,
+	// e.g. "home-manager".
+	Name() string
+	// Kind is the kind of entries of the source.
+	Kind() SourceKind
+	// Prefix is the route of the entries of the source, e.g. "home-manager/option/".
+	Prefix() string
+	// Fetch downloads the raw data of the source for a channel.
+	Fetch(channel Channel) ([]byte, error)
+	// Normalize converts the raw data of the source into packages or options.
+	Normalize(raw []byte, channel Channel) (Data, error)
+}
+
+// sources are the registered sources, in order of priority.
+var sources []Source
+
+// RegisterSource adds a source to the index. Sources registered first come
+// first when sorting the results by source.
+// It panics if a source of the same name is already registered.
+func RegisterSource(source Source) {
+	if _, ok := SourceByName(source.Name()); ok {
+		panic(fmt.Sprintf("source %q is already registered", source.Name()))
+	}
+	sources = append(sources, source)
+}
+
+// Sources returns the registered sources, in order of priority.
+func Sources() []Source {
+	return slices.Clone(sources)
+}
+
+// SourceByName returns the registered source of the given name.
+func SourceByName(name string) (Source, bool) {
+	for _, source := range sources {
+		if source.Name() == name {
+			return source, true
+		}
+	}
+	return nil, false
+}
+
+// SourceNames returns the names of the registered sources, in order of priority.
+func SourceNames() []string {
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		names[i] = source.Name()
+	}
+	return names
+}
+
+// SourceStatus is the health of a source after the last download of the index.
+type SourceStatus string
+
+const (
+	StatusOK     SourceStatus = "ok"     // downloaded successfully
+	StatusStale  SourceStatus = "stale"  // failed, the data of the previous download is kept
+	StatusFailed SourceStatus = "failed" // failed, without previous data
+)
+
+// sourceInfo returns the key of a property of a source in Index.Info,
+// e.g. "homemanager-status".
+func sourceInfo(source, property string) string {
+	return strings.ReplaceAll(source, "-", "") + "-" + property
+}
+
+// lengthInfo returns the key of the number of entries of a source in Index.Info,
+// e.g. "homemanager-length".
+func lengthInfo(source string) string {
+	return sourceInfo(source, "length")
+}
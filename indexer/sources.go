@@ -0,0 +1,254 @@
+package indexer
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/anotherhadi/search-nixos-api/indexer/darwin"
+	"github.com/anotherhadi/search-nixos-api/indexer/homemanager"
+	"github.com/anotherhadi/search-nixos-api/indexer/nixos"
+	"github.com/anotherhadi/search-nixos-api/indexer/nixpkgs"
+	"github.com/anotherhadi/search-nixos-api/indexer/nur"
+)
+
+// The built-in sources, read from the nix-json releases.
+func init() {
+	RegisterSource(releaseSource{
+		name: "nixpkgs", kind: KindPackage, prefix: nixpkgs.Prefix,
+		file: "nixpkgs.json", normalize: normalizeNixpkgs,
+	})
+	RegisterSource(releaseSource{
+		name: "nixos", kind: KindOption, prefix: nixos.Prefix,
+		file: "nixos.json", normalize: normalizeNixos,
+	})
+	RegisterSource(releaseSource{
+		name: "home-manager", kind: KindOption, prefix: homemanager.Prefix,
+		file: "home-manager.json", normalize: normalizeHomemanager,
+	})
+	RegisterSource(releaseSource{
+		name: "darwin", kind: KindOption, prefix: darwin.Prefix,
+		file: "darwin.json", normalize: normalizeDarwin,
+	})
+	RegisterSource(releaseSource{
+		name: "nur", kind: KindPackage, prefix: nur.Prefix,
+		file: "nur.json", normalize: normalizeNur,
+	})
+}
+
+// releaseSource is a source read from a file of the release of a channel.
+type releaseSource struct {
+	name      string
+	kind      SourceKind
+	prefix    string
+	file      string
+	normalize func(raw []byte, channel Channel) (Data, error)
+}
+
+func (s releaseSource) Name() string     { return s.name }
+func (s releaseSource) Kind() SourceKind { return s.kind }
+func (s releaseSource) Prefix() string   { return s.prefix }
+
+func (s releaseSource) Fetch(channel Channel) ([]byte, error) {
+	return readRelease(channel, s.file)
+}
+
+func (s releaseSource) Normalize(raw []byte, channel Channel) (Data, error) {
+	return s.normalize(raw, channel)
+}
+
+func normalizeNixos(raw []byte, channel Channel) (Data, error) {
+	return normalizeOptionsDoc(raw, "nixpkgs", func(d string) string {
+		return "https://github.com/NixOS/nixpkgs/blob/" + channel.Commit + "/" + d
+	})
+}
+
+// normalizeOptionsDoc normalizes options in the format of nixosOptionsDoc,
+// with the URL of each declaration.
+func normalizeOptionsDoc(raw []byte, source string, declarationURL func(d string) string) (Data, error) {
+	jsonObject := map[string]nixos.Package{}
+	if err := json.Unmarshal(raw, &jsonObject); err != nil {
+		return Data{}, err
+	}
+	options := Options{}
+	for k, v := range jsonObject {
+		opt := Option{
+			Source:       source,
+			Type:         v.Type,
+			Description:  v.Description,
+			Declarations: []string{},
+			Default:      v.Default.Text,
+			Example:      v.Example.Text,
+		}
+		for _, d := range v.Declarations {
+			opt.Declarations = append(opt.Declarations, declarationURL(d))
+		}
+		options[k] = opt
+	}
+	return Data{Options: options}, nil
+}
+
+func normalizeHomemanager(raw []byte, channel Channel) (Data, error) {
+	jsonObject := map[string]homemanager.Package{}
+	if err := json.Unmarshal(raw, &jsonObject); err != nil {
+		return Data{}, err
+	}
+	options := Options{}
+	for k, v := range jsonObject {
+		opt := Option{
+			Source:       "home-manager",
+			Type:         v.Type,
+			Description:  v.Description,
+			Declarations: []string{},
+			Default:      v.Default.Text,
+			Example:      v.Example.Text,
+		}
+		for _, d := range v.Declarations {
+			opt.Declarations = append(opt.Declarations, d.URL)
+		}
+		options[k] = opt
+	}
+	return Data{Options: options}, nil
+}
+
+func normalizeDarwin(raw []byte, channel Channel) (Data, error) {
+	jsonObject := darwin.Darwin{}
+	if err := json.Unmarshal(raw, &jsonObject); err != nil {
+		return Data{}, err
+	}
+	options := Options{}
+	for k, v := range jsonObject.Packages {
+		opt := Option{
+			Source:       "darwin",
+			Type:         v.Type,
+			Description:  v.Description,
+			Declarations: v.DeclaredBy,
+			Default:      v.Default,
+			Example:      v.Example,
+		}
+		options[k] = opt
+	}
+	return Data{Options: options}, nil
+}
+
+func normalizeNixpkgs(raw []byte, channel Channel) (Data, error) {
+	jsonObject := nixpkgs.Nixpkgs{}
+	if err := json.Unmarshal(raw, &jsonObject); err != nil {
+		return Data{}, err
+	}
+	packages := Packages{}
+	for k, v := range jsonObject.Packages {
+		pkg := Package{
+			Source:               "nixpkgs",
+			Name:                 v.Meta.Name,
+			Version:              v.Version,
+			Description:          v.Meta.Description,
+			LongDescription:      v.Meta.LongDescription,
+			MainProgram:          v.Meta.MainProgram,
+			Licenses:             []License{},
+			Maintainers:          []Maintainer{},
+			KnownVulnerabilities: []string{},
+			Broken:               v.Meta.Broken,
+			Unfree:               v.Meta.Unfree,
+			Position:             v.Meta.Position,
+			PositionUrl:          v.Meta.Position,
+		}
+
+		pkg.PositionUrl = "https://github.com/NixOS/nixpkgs/blob/" + channel.Commit + "/" + strings.Replace(
+			v.Meta.Position,
+			":",
+			"#L",
+			1,
+		)
+		if v.Meta.KnownVulnerabilities != nil && len(v.Meta.KnownVulnerabilities) != 0 {
+			pkg.KnownVulnerabilities = v.Meta.KnownVulnerabilities
+			pkg.Vulnerable = true
+		} else {
+			pkg.KnownVulnerabilities = []string{}
+		}
+		if v.Meta.Homepages != nil {
+			pkg.Homepages = v.Meta.Homepages
+		} else {
+			pkg.Homepages = []string{}
+		}
+		if v.Meta.Platforms != nil {
+			pkg.Platforms = v.Meta.Platforms
+		} else {
+			pkg.Platforms = []string{}
+		}
+		for _, l := range v.Meta.Licenses {
+			pkg.Licenses = append(pkg.Licenses, License{
+				FullName: l.FullName,
+				Free:     l.Free,
+				SpdxID:   l.SpdxID,
+			})
+		}
+		for _, m := range v.Meta.Maintainers {
+			pkg.Maintainers = append(pkg.Maintainers, Maintainer{
+				Name:     m.Name,
+				Email:    m.Email,
+				GitHub:   m.GitHub,
+				GithubId: m.GithubId,
+			})
+		}
+		packages[k] = simplifyPlatform(pkg)
+	}
+	return Data{Packages: packages}, nil
+}
+
+func normalizeNur(raw []byte, channel Channel) (Data, error) {
+	jsonObject := nur.Nur{}
+	if err := json.Unmarshal(raw, &jsonObject); err != nil {
+		return Data{}, err
+	}
+	packages := Packages{}
+	for k, v := range jsonObject.Packages {
+		pkg := Package{
+			Source:               "nur",
+			Name:                 v.Meta.Name,
+			Version:              v.Version,
+			Description:          v.Meta.Description,
+			LongDescription:      v.Meta.LongDescription,
+			MainProgram:          v.Meta.MainProgram,
+			Licenses:             []License{},
+			Maintainers:          []Maintainer{},
+			KnownVulnerabilities: []string{},
+			Broken:               v.Meta.Broken,
+			Unfree:               v.Meta.Unfree,
+			Position:             v.Meta.Position,
+			PositionUrl:          v.Meta.Position,
+		}
+		if v.Meta.KnownVulnerabilities != nil && len(v.Meta.KnownVulnerabilities) != 0 {
+			pkg.KnownVulnerabilities = v.Meta.KnownVulnerabilities
+			pkg.Vulnerable = true
+		} else {
+			pkg.KnownVulnerabilities = []string{}
+		}
+		if v.Meta.Homepages != nil {
+			pkg.Homepages = v.Meta.Homepages
+		} else {
+			pkg.Homepages = []string{}
+		}
+		if v.Meta.Platforms != nil {
+			pkg.Platforms = v.Meta.Platforms
+		} else {
+			pkg.Platforms = []string{}
+		}
+		for _, l := range v.Meta.Licenses {
+			pkg.Licenses = append(pkg.Licenses, License{
+				FullName: l.FullName,
+				Free:     l.Free,
+				SpdxID:   l.SpdxID,
+			})
+		}
+		for _, m := range v.Meta.Maintainers {
+			pkg.Maintainers = append(pkg.Maintainers, Maintainer{
+				Name:     m.Name,
+				Email:    m.Email,
+				GitHub:   m.GitHub,
+				GithubId: m.GithubId,
+			})
+		}
+		packages[k] = simplifyPlatform(pkg)
+	}
+	return Data{Packages: packages}, nil
+}
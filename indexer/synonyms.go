@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+)
+
+// SynonymsFile is the name of the synonym dictionary, next to the index.
+const SynonymsFile = "synonyms.json"
+
+// Synonyms maps a search term to the terms it is expanded to, e.g. "k8s" to "kubernetes".
+// Terms are lowercased, and the expansion only goes one way.
+type Synonyms map[string][]string
+
+// DefaultSynonyms are common aliases of the Nix ecosystem.
+var DefaultSynonyms = Synonyms{
+	"postgres":   {"postgresql"},
+	"pg":         {"postgresql"},
+	"k8s":        {"kubernetes"},
+	"chrome":     {"chromium", "google-chrome"},
+	"vscode":     {"vscodium"},
+	"golang":     {"go"},
+	"node":       {"nodejs"},
+	"py":         {"python3"},
+	"nvim":       {"neovim"},
+	"hm":         {"home-manager"},
+	"tf":         {"terraform", "opentofu"},
+	"gpg":        {"gnupg"},
+	"ssh":        {"openssh"},
+	"pwsh":       {"powershell"},
+	"mysql":      {"mariadb"},
+	"youtube-dl": {"yt-dlp"},
+}
+
+// LoadSynonyms reads a synonym dictionary from a JSON file mapping each term
+// to a list of synonyms, and merges it into the default one.
+// The defaults are returned as is if the file doesn't exist.
+func LoadSynonyms(path string) (Synonyms, error) {
+	synonyms := Synonyms{}
+	for term, alternatives := range DefaultSynonyms {
+		synonyms[term] = alternatives
+	}
+	if !DoesFileExist(path) {
+		return synonyms, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return synonyms, err
+	}
+	custom := Synonyms{}
+	if err := json.Unmarshal(content, &custom); err != nil {
+		return synonyms, err
+	}
+	for term, alternatives := range custom {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" {
+			continue
+		}
+		synonyms[term] = alternatives
+	}
+	return synonyms, nil
+}
+
+// Expansion is a term of a query expanded to its synonyms.
+type Expansion struct {
+	Term     string   `json:"term"`
+	Synonyms []string `json:"synonyms"`
+}
+
+// ExpandSynonyms replaces the terms of a query having synonyms in the dictionary
+// of the index with the alternative of the term and its synonyms, e.g.
+// "k8s" becomes "(k8s OR kubernetes)". Qualifiers are left as is.
+// Synonyms only match whole tokens, so that "go" doesn't match "gogs".
+// It returns the expanded query and the expansions applied.
+func (index Index) ExpandSynonyms(q Query) (Query, []Expansion) {
+	synonyms := index.synonyms
+	if synonyms == nil {
+		synonyms = DefaultSynonyms
+	}
+
+	expansions := []Expansion{}
+	var expand func(n Node) Node
+	expand = func(n Node) Node {
+		if n.Operator != OperatorClause {
+			children := make([]Node, len(n.Children))
+			for i, child := range n.Children {
+				children[i] = expand(child)
+			}
+			n.Children = children
+			return n
+		}
+
+		term := strings.ToLower(n.Clause.Value)
+		alternatives := synonyms[term]
+		if n.Clause.Qualifier != "" || len(alternatives) == 0 {
+			return n
+		}
+		if !slices.ContainsFunc(expansions, func(e Expansion) bool { return e.Term == term }) {
+			expansions = append(expansions, Expansion{Term: term, Synonyms: alternatives})
+		}
+
+		or := Node{Operator: OperatorOr, Negated: n.Negated}
+		n.Negated = false
+		or.Children = append(or.Children, n)
+		for _, alternative := range alternatives {
+			or.Children = append(or.Children, Node{
+				Operator: OperatorClause,
+				Clause:   Clause{Value: alternative, Exact: true},
+			})
+		}
+		return or
+	}
+
+	q.Root = expand(q.Root)
+	return q, expansions
+}
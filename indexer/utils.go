@@ -1,12 +1,15 @@
 package indexer
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func DoesFileExist(filename string) bool {
@@ -17,44 +20,79 @@ func DoesFileExist(filename string) bool {
 	return true
 }
 
+// httpClient downloads the releases. Its timeout lets a stalled mirror fall back to the next one.
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
 func getFileFromUrl(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to get file from url: %s", url)
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get file from url: %s", redactURL(url))
 	}
 	return resp.Body, nil
 }
 
+// url is the default location of the releases.
 const url = "https://github.com/anotherhadi/nix-json/releases/latest/download/"
 
-func downloadRelease(filename string) (io.ReadCloser, error) {
-	return getFileFromUrl(url + filename)
+// ParseMirrors parses a comma separated list of base URLs of releases.
+func ParseMirrors(s string) []string {
+	mirrors := []string{}
+	for mirror := range strings.SplitSeq(s, ",") {
+		if mirror = strings.TrimSpace(mirror); mirror != "" {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	return mirrors
 }
 
-func downloadAndReadRelease(filename string, unmarshalTo any) error {
-	log.Println("Downloading", filename, "...")
-	jsonfile, err := downloadRelease(filename)
+// downloadRelease opens a file of a release. The base URL is either an http(s)
+// URL, a file:// URL or the path of a directory holding the files of the release.
+func downloadRelease(baseUrl, filename string) (io.ReadCloser, error) {
+	if path, ok := strings.CutPrefix(baseUrl, "file://"); ok {
+		return os.Open(filepath.Join(path, filename))
+	}
+	if !strings.Contains(baseUrl, "://") {
+		return os.Open(filepath.Join(baseUrl, filename))
+	}
+	return getFileFromUrl(strings.TrimSuffix(baseUrl, "/") + "/" + filename)
+}
+
+// readRelease downloads and reads a file of the release of a channel,
+// trying its mirrors in order until one of them succeeds.
+func readRelease(channel Channel, filename string) ([]byte, error) {
+	if err := channel.CheckPin(); err != nil {
+		return nil, err
+	}
+	errs := []error{}
+	for _, baseUrl := range channel.URLs() {
+		content, err := readReleaseFrom(baseUrl, filename)
+		if err == nil {
+			return content, nil
+		}
+		log.Println("Failed to download", filename, "from", redactURL(baseUrl)+":", err)
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// readReleaseFrom downloads and reads a file of a release from a base URL.
+func readReleaseFrom(baseUrl, filename string) ([]byte, error) {
+	log.Println("Downloading", filename, "from", redactURL(baseUrl), "...")
+	jsonfile, err := downloadRelease(baseUrl, filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer jsonfile.Close()
 
 	log.Println("Reading", filename, "...")
 	content, err := io.ReadAll(jsonfile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Println("Read", filename, "successfully :", len(content), "bytes")
-	log.Println("Unmarshalling", filename, "...")
-
-	err = json.Unmarshal(content, &unmarshalTo)
-	if err != nil {
-		return err
-	}
-	log.Println("Unmarshalled", filename, "successfully")
-
-	return nil
+	return content, nil
 }
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SourceWeights multiply the relevance of the results of each section of the index.
+// Sections without a weight keep their relevance.
+type SourceWeights map[string]float64
+
+// DefaultSourceWeights favor the canonical sources over the NUR, whose many
+// near-duplicate packages would otherwise push nixpkgs packages down.
+var DefaultSourceWeights = SourceWeights{
+	"nixpkgs":      1,
+	"nixos":        1,
+	"home-manager": 1,
+	"darwin":       1,
+	"nur":          0.5,
+}
+
+// ParseSourceWeights parses source weights, such as "nur=0.2,nixos=1.5".
+func ParseSourceWeights(s string) (SourceWeights, error) {
+	weights := SourceWeights{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		source, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid source weight %q, expected source=weight", pair)
+		}
+		if !slices.Contains(SourceNames(), source) {
+			return nil, fmt.Errorf(
+				"unknown source %q, expected one of %s",
+				source,
+				strings.Join(SourceNames(), ", "),
+			)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weight %q for source %q", value, source)
+		}
+		weights[source] = weight
+	}
+	return weights, nil
+}
+
+// Merge returns the weights overridden by other ones.
+func (w SourceWeights) Merge(other SourceWeights) SourceWeights {
+	res := SourceWeights{}
+	for source, weight := range w {
+		res[source] = weight
+	}
+	for source, weight := range other {
+		res[source] = weight
+	}
+	return res
+}
+
+// weight returns the weight of a section.
+func (w SourceWeights) weight(section string) float64 {
+	if weight, ok := w[section]; ok {
+		return weight
+	}
+	return 1
+}
+
+// attributeName returns the name packages are grouped by in GroupAlternates:
+// the key for nixpkgs, and the last path segment for the NUR, whose keys are
+// prefixed by their repository.
+func attributeName(item PackageOrOption) string {
+	if item.Section == "nur" {
+		return item.Key[strings.LastIndex(item.Key, ".")+1:]
+	}
+	return item.Key
+}
+
+// GroupAlternates groups the packages sharing the same attribute name in nixpkgs
+// and the NUR: only the first one is kept, with the others as its alternates.
+// Options are never grouped.
+func GroupAlternates(items []PackageOrOption) []PackageOrOption {
+	res := []PackageOrOption{}
+	groups := map[string]int{} // attribute name -> index in res
+	for _, item := range items {
+		if item.Type != "package" {
+			res = append(res, item)
+			continue
+		}
+		name := attributeName(item)
+		if i, ok := groups[name]; ok {
+			res[i].Alternates = append(res[i].Alternates, item)
+			continue
+		}
+		groups[name] = len(res)
+		res = append(res, item)
+	}
+	return res
+}
@@ -0,0 +1,23 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSourceWeights(t *testing.T) {
+	weights, err := ParseSourceWeights(" nur=0.2, nixos=1.5,,darwin=0 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SourceWeights{"nur": 0.2, "nixos": 1.5, "darwin": 0}
+	if !reflect.DeepEqual(weights, want) {
+		t.Errorf("ParseSourceWeights() = %v, want %v", weights, want)
+	}
+
+	for _, s := range []string{"nur", "foo=1", "nur=x", "nur=-1", "nur=NaN", "nur=nan", "nur=Inf", "nur=+Inf", "nur=1e400"} {
+		if _, err := ParseSourceWeights(s); err == nil {
+			t.Errorf("ParseSourceWeights(%q) returned no error", s)
+		}
+	}
+}